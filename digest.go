@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// digestAuthorization answers the HTTP Digest (RFC 2617) challenge of res for
+// req, using MD5 and qop=auth
+func digestAuthorization(req *http.Request, res *http.Response, username string, password string) (string, error) {
+	challenge := res.Header.Get("WWW-Authenticate")
+	if !strings.HasPrefix(challenge, "Digest ") {
+		return "", fmt.Errorf("unsupported authentication challenge %q", challenge)
+	}
+
+	params := parseDigestChallenge(strings.TrimPrefix(challenge, "Digest "))
+	if algorithm := params["algorithm"]; algorithm != "" && !strings.EqualFold(algorithm, "MD5") {
+		return "", fmt.Errorf("unsupported digest algorithm %s", algorithm)
+	}
+
+	cnonceBytes := make([]byte, 8)
+	if _, err := rand.Read(cnonceBytes); err != nil {
+		return "", err
+	}
+	cnonce := hex.EncodeToString(cnonceBytes)
+	nc := "00000001"
+	uri := req.URL.RequestURI()
+
+	ha1 := md5Hex(username + ":" + params["realm"] + ":" + password)
+	ha2 := md5Hex(req.Method + ":" + uri)
+
+	var response string
+	if params["qop"] == "" {
+		response = md5Hex(ha1 + ":" + params["nonce"] + ":" + ha2)
+	} else {
+		response = md5Hex(ha1 + ":" + params["nonce"] + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+	}
+
+	authorization := fmt.Sprintf(
+		`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=MD5, response="%s"`,
+		username, params["realm"], params["nonce"], uri, response,
+	)
+	if params["qop"] != "" {
+		authorization += fmt.Sprintf(`, qop=auth, nc=%s, cnonce="%s"`, nc, cnonce)
+	}
+	if params["opaque"] != "" {
+		authorization += fmt.Sprintf(`, opaque="%s"`, params["opaque"])
+	}
+
+	return authorization, nil
+}
+
+// parseDigestChallenge parses the comma-separated key=value pairs of a
+// challenge. Values may be quoted and contain commas.
+func parseDigestChallenge(challenge string) map[string]string {
+	params := make(map[string]string)
+
+	for challenge != "" {
+		var key, value string
+		key, challenge, _ = strings.Cut(challenge, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+
+		challenge = strings.TrimSpace(challenge)
+		if strings.HasPrefix(challenge, `"`) {
+			value, challenge, _ = strings.Cut(challenge[1:], `"`)
+			_, challenge, _ = strings.Cut(challenge, ",")
+		} else {
+			value, challenge, _ = strings.Cut(challenge, ",")
+		}
+
+		params[key] = strings.TrimSpace(value)
+	}
+
+	return params
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
@@ -73,6 +73,11 @@ func (c *discordClient) postError(webhook *Webhook) error {
 	return c.post(webhook)
 }
 
+func (c *discordClient) postWarning(webhook *Webhook) error {
+	webhook.Embeds[0].Color = 16098851
+	return c.post(webhook)
+}
+
 func (c *discordClient) postSuccess(webhook *Webhook) error {
 	webhook.Embeds[0].Color = 5747840
 	return c.post(webhook)
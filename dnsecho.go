@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const dnsEchoTimeout = 5 * time.Second
+
+// dnsEchoResolver asks a nameserver which answers a special name with the
+// address of the client, like myip.opendns.com at resolver1.opendns.com. Each
+// family is queried over its own transport (udp4 or udp6).
+type dnsEchoResolver struct {
+	provider string
+	// server is the host:port of the nameserver
+	server string
+	// qname is the name to look up
+	qname string
+	// qtype is either A (A or AAAA depending on the family) or TXT
+	qtype string
+}
+
+var _ IPResolver = (*dnsEchoResolver)(nil)
+
+var dnsEchoProviders = map[string]dnsEchoResolver{
+	"opendns": {server: "resolver1.opendns.com:53", qname: "myip.opendns.com.", qtype: "A"},
+	"google":  {server: "ns1.google.com:53", qname: "o-o.myaddr.l.google.com.", qtype: "TXT"},
+}
+
+func newDNSEchoResolver(provider string, opts url.Values) (*dnsEchoResolver, error) {
+	if provider == "" {
+		provider = "opendns"
+	}
+
+	r, ok := dnsEchoProviders[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown dns provider %q, expected opendns or google", provider)
+	}
+	r.provider = provider
+
+	if server := opts.Get("server"); server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		r.server = server
+	}
+
+	return &r, nil
+}
+
+func (r *dnsEchoResolver) Name() string {
+	return "dns:" + r.provider
+}
+
+func (r *dnsEchoResolver) Resolve(families ipFamily) (*IPAddrs, error) {
+	return resolveFamilies(families, r.lookup)
+}
+
+// lookup queries the nameserver over the transport of family
+func (r *dnsEchoResolver) lookup(family ipFamily) (net.IP, error) {
+	// suffix of the networks (ip4, udp6...) matching family
+	suffix := "4"
+	if family == familyV6 {
+		suffix = "6"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dnsEchoTimeout)
+	defer cancel()
+
+	host, port, err := net.SplitHostPort(r.server)
+	if err != nil {
+		return nil, err
+	}
+
+	serverIPs, err := net.DefaultResolver.LookupIP(ctx, "ip"+suffix, host)
+	if err != nil {
+		return nil, err
+	}
+	server := net.JoinHostPort(serverIPs[0].String(), port)
+
+	// dialErr is kept since the *net.DNSError returned by the lookups does
+	// not wrap it, and the caller needs it to tell an unreachable family
+	var dialErr error
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, _ string) (net.Conn, error) {
+			// network is either udp or tcp: pin it to the family
+			var d net.Dialer
+			conn, err := d.DialContext(ctx, network[:3]+suffix, server)
+			if err != nil {
+				dialErr = err
+			}
+			return conn, err
+		},
+	}
+
+	if r.qtype == "TXT" {
+		txts, err := resolver.LookupTXT(ctx, r.qname)
+		if unreachable(dialErr) {
+			return nil, dialErr
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, txt := range txts {
+			ip := net.ParseIP(strings.TrimSpace(txt))
+			if ip != nil && (ip.To4() != nil) == (family == familyV4) {
+				return ip, nil
+			}
+		}
+		return nil, fmt.Errorf("no %s address in the TXT records of %s: %q", family, r.qname, txts)
+	}
+
+	ips, err := resolver.LookupIP(ctx, "ip"+suffix, r.qname)
+	if unreachable(dialErr) {
+		return nil, dialErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ips[0], nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"net/url"
+	"testing"
+)
+
+const (
+	dnsTypeA    = 1
+	dnsTypeTXT  = 16
+	dnsTypeAAAA = 28
+)
+
+// startDNSEchoServer answers A, AAAA and TXT queries with the address of the
+// client, like resolver1.opendns.com and ns1.google.com do
+func startDNSEchoServer(t *testing.T, network string, address string) string {
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		t.Skipf("failed to listen on %s: %v", address, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if res := dnsEchoAnswer(buf[:n], addr.(*net.UDPAddr).IP); res != nil {
+				_, _ = conn.WriteTo(res, addr)
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func dnsEchoAnswer(query []byte, client net.IP) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+
+	// skip the labels of the question name
+	end := 12
+	for end < len(query) && query[end] != 0 {
+		end += int(query[end]) + 1
+	}
+	end += 5 // terminal label, qtype and qclass
+	if end > len(query) {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(query[end-4 : end-2])
+
+	var rdata []byte
+	switch {
+	case qtype == dnsTypeA && client.To4() != nil:
+		rdata = client.To4()
+	case qtype == dnsTypeAAAA && client.To4() == nil:
+		rdata = client.To16()
+	case qtype == dnsTypeTXT:
+		txt := client.String()
+		rdata = append([]byte{byte(len(txt))}, txt...)
+	}
+
+	res := make([]byte, 12, 512)
+	copy(res[0:2], query[0:2])
+	binary.BigEndian.PutUint16(res[2:4], 0x8180)
+	binary.BigEndian.PutUint16(res[4:6], 1)
+	res = append(res, query[12:end]...)
+
+	if rdata != nil {
+		binary.BigEndian.PutUint16(res[6:8], 1)
+		answer := make([]byte, 12)
+		binary.BigEndian.PutUint16(answer[0:2], 0xc00c)
+		binary.BigEndian.PutUint16(answer[2:4], qtype)
+		binary.BigEndian.PutUint16(answer[4:6], 1)
+		binary.BigEndian.PutUint32(answer[6:10], 0)
+		binary.BigEndian.PutUint16(answer[10:12], uint16(len(rdata)))
+		res = append(append(res, answer...), rdata...)
+	}
+
+	return res
+}
+
+func TestDNSEchoResolver(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		network  string
+		address  string
+		wantV4   string
+		wantV6   string
+	}{
+		{name: "opendns ipv4", provider: "opendns", network: "udp4", address: "127.0.0.1:0", wantV4: "127.0.0.1"},
+		{name: "google ipv4", provider: "google", network: "udp4", address: "127.0.0.1:0", wantV4: "127.0.0.1"},
+		{name: "opendns ipv6", provider: "opendns", network: "udp6", address: "[::1]:0", wantV6: "::1"},
+		{name: "google ipv6", provider: "google", network: "udp6", address: "[::1]:0", wantV6: "::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startDNSEchoServer(t, tt.network, tt.address)
+
+			resolver, err := newDNSEchoResolver(tt.provider, url.Values{"server": {server}})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			ipAddrs, err := resolver.Resolve(familyBoth)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := ipString(ipAddrs.V4); got != tt.wantV4 {
+				t.Errorf("got V4 %s, want %s", got, tt.wantV4)
+			}
+			if got := ipString(ipAddrs.V6); got != tt.wantV6 {
+				t.Errorf("got V6 %s, want %s", got, tt.wantV6)
+			}
+		})
+	}
+}
@@ -2,22 +2,118 @@ package main
 
 import (
 	"fmt"
-	"io"
 	"log"
 	"net"
 
 	"github.com/pkg/errors"
 )
 
+// What to do with the A or AAAA rrset of a managed family when no address of
+// this family was found, like the AAAA rrset on an IPv4-only connection
+const (
+	staleKeep   = "keep"
+	staleDelete = "delete"
+	staleWarn   = "warn"
+)
+
+// What to do when the record has no A nor AAAA rrset yet
+const (
+	createRecord = "create"
+	createFail   = "fail"
+	createAsk    = "ask"
+)
+
 // DynDNS holds all the required dependencies
 type DynDNS struct {
-	gandiClient   *gandiClient
+	provider      DNSProvider
 	discordClient *discordClient
+	resolver      IPResolver
+	policy        *ipPolicy
+	// families are the address families whose records are managed
+	families ipFamily
+	// staleRecords is either staleKeep, staleDelete or staleWarn
+	staleRecords string
+	// lanHosts get an AAAA record in the detected IPv6 prefix
+	lanHosts     []*lanHost
+	prefixLength int
+	// managedValues restricts the updates to the values published by the
+	// previous runs, remembered in state, so that the other values of the
+	// rrsets are kept
+	managedValues bool
+	state         *state
+	stateFile     string
+	// createIfMissing is either createRecord (default), createFail or
+	// createAsk
+	createIfMissing string
 }
 
 type IPAddrs struct {
 	V4 *net.IP `json:"IPAddress"`
 	V6 *net.IP `json:"IPv6Address"`
+	// failures explains why the detection of a family failed while the
+	// other family succeeded. A family without address nor failure has no
+	// address at all.
+	failures map[ipFamily]error
+}
+
+// set stores ip as the address of family
+func (ipAddrs *IPAddrs) set(family ipFamily, ip net.IP) error {
+	if (ip.To4() != nil) != (family == familyV4) {
+		return fmt.Errorf("expected an %s address, got %s", family, ip)
+	}
+
+	if family == familyV4 {
+		ipAddrs.V4 = &ip
+	} else {
+		ipAddrs.V6 = &ip
+	}
+	return nil
+}
+
+// only returns a copy of ipAddrs restricted to families
+func (ipAddrs *IPAddrs) only(families ipFamily) *IPAddrs {
+	restricted := &IPAddrs{}
+	if families.has(familyV4) {
+		restricted.V4 = ipAddrs.V4
+	}
+	if families.has(familyV6) {
+		restricted.V6 = ipAddrs.V6
+	}
+	for family, err := range ipAddrs.failures {
+		if families.has(family) {
+			restricted.fail(family, err)
+		}
+	}
+	return restricted
+}
+
+// fail records that the detection of family failed with err
+func (ipAddrs *IPAddrs) fail(family ipFamily, err error) {
+	if ipAddrs.failures == nil {
+		ipAddrs.failures = make(map[ipFamily]error)
+	}
+	ipAddrs.failures[family] = err
+}
+
+// failed returns the families whose detection failed
+func (ipAddrs *IPAddrs) failed() ipFamily {
+	var families ipFamily
+	for family := range ipAddrs.failures {
+		families |= family
+	}
+	return families
+}
+
+// families returns the families having an address
+func (ipAddrs *IPAddrs) families() ipFamily {
+	var families ipFamily
+	if ipAddrs.V4 != nil {
+		families |= familyV4
+	}
+	if ipAddrs.V6 != nil {
+		families |= familyV6
+	}
+	return families
 }
 
 func (ipAddrs *IPAddrs) String() string {
@@ -43,67 +139,189 @@ func (ipAddrs *IPAddrs) values() []*net.IP {
 	return values
 }
 
-// resolveIPs finds the current IP(s) addresses pointu
-func (d *DynDNS) resolveIPs() (*IPAddrs, error) {
-	res, err := defaultHTTP.Get("https://api64.ipify.org")
+// resolveIPs finds the current IP(s) addresses of the managed families using
+// the configured resolver
+func (dyndns *DynDNS) resolveIPs() (*IPAddrs, error) {
+	ipAddrs, err := dyndns.resolver.Resolve(dyndns.families)
 	if err != nil {
 		return nil, err
 	}
-	defer res.Body.Close()
 
-	body, err := io.ReadAll(res.Body)
+	ipAddrs = ipAddrs.only(dyndns.families)
+	if ipAddrs.V4 == nil && ipAddrs.V6 == nil {
+		return nil, fmt.Errorf("no %s address found", dyndns.families)
+	}
+	return ipAddrs, nil
+}
+
+// execute check the current IPs, and the one defines in the DNS records.
+// If necessary, it updates the DNS records and notify Discord.
+func (dyndns *DynDNS) execute(domain string, record string, ttl int, alwaysNotify bool) error {
+	resolvedIPs, err := dyndns.resolveIPs()
 	if err != nil {
-		return nil, err
+		return err
 	}
+	log.Printf("Current dynamic IP(s): %s\n", resolvedIPs)
+
+	detected := resolvedIPs.families()
+	failed := resolvedIPs.failed()
 
-	ip := net.ParseIP(string(body))
-	if ip == nil {
-		return nil, fmt.Errorf("failed to parse ip: %s", body)
+	resolvedIPs, rejected, err := dyndns.policy.filter(resolvedIPs)
+	if err != nil {
+		return err
 	}
 
-	if ip.To4() != nil {
-		// if ipv4 return here because there are not IPv6
-		return &IPAddrs{V4: &ip}, nil
+	// families managed but without any address: their records are stale.
+	// The families rejected by the policy or whose detection failed are left
+	// untouched.
+	stale := dyndns.families &^ detected &^ failed
+
+	for _, family := range failed.split() {
+		err := dyndns.warnFailedFamily(domain, record, family, resolvedIPs.failures[family])
+		if err != nil {
+			return err
+		}
 	}
 
-	res, err = defaultHTTP.Get("https://api.ipify.org")
-	if err != nil {
-		return nil, err
+	for _, rejectedErr := range rejected {
+		log.Printf("warning: %v - skipping\n", rejectedErr)
+
+		err := dyndns.discordClient.postWarning(&Webhook{
+			Embeds: []Embed{
+				{
+					Title:       fmt.Sprintf("IP address rejected for record %s.%s - skipping", record, domain),
+					Description: rejectedErr.Error(),
+				},
+			},
+		})
+		if err != nil {
+			return errors.Wrap(err, "failed to send message to discord")
+		}
 	}
-	defer res.Body.Close()
 
-	body, err = io.ReadAll(res.Body)
+	err = dyndns.sync(domain, record, resolvedIPs, stale, ttl, alwaysNotify)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	if len(dyndns.lanHosts) == 0 || failed.has(familyV6) {
+		return degradedOrNil(failed)
 	}
 
-	ip2 := net.ParseIP(string(body))
-	if ip2 == nil {
-		return nil, fmt.Errorf("failed to parse ip: %s", body)
+	if resolvedIPs.V6 == nil && detected.has(familyV6) {
+		// the prefix was rejected by the policy in skip mode
+		log.Println("warning: the IPv6 prefix was rejected - skipping the records of the LAN hosts")
+		return degradedOrNil(failed)
+	}
+
+	if resolvedIPs.V6 == nil {
+		return fmt.Errorf("no IPv6 prefix found to update the records of the LAN hosts")
+	}
+
+	for _, host := range dyndns.lanHosts {
+		ip := host.address(*resolvedIPs.V6, dyndns.prefixLength)
+		log.Printf("Address of LAN host %s: %s\n", host.record, ip)
+
+		err := dyndns.sync(domain, host.record, &IPAddrs{V6: &ip}, 0, ttl, alwaysNotify)
+		if err != nil {
+			return err
+		}
 	}
 
-	return &IPAddrs{V6: &ip, V4: &ip2}, nil
+	return degradedOrNil(failed)
 }
 
-// execute check the current IPs, and the one defines in the DNS records.
-// If necessary, it updates the DNS records and notify Discord.
-func (dyndns *DynDNS) execute(domain string, record string, ttl int, alwaysNotify bool) error {
-	resolvedIPs, err := dyndns.resolveIPs()
-	if err != nil {
-		return err
+// degradedError is returned when the records were synced, but the detection
+// of some families failed so their records were left untouched
+type degradedError struct {
+	failed ipFamily
+}
+
+func (e *degradedError) Error() string {
+	return fmt.Sprintf("failed to detect the %s address, its records were left untouched", e.failed)
+}
+
+func degradedOrNil(failed ipFamily) error {
+	if failed == 0 {
+		return nil
 	}
-	log.Printf("Current dynamic IP(s): %s\n", resolvedIPs)
+	return &degradedError{failed}
+}
+
+func (dyndns *DynDNS) warnFailedFamily(domain string, record string, family ipFamily, failure error) error {
+	log.Printf("warning: failed to detect the %s address: %v - leaving the %s record untouched\n", family, failure, rrsetTypeOf(family))
+
+	err := dyndns.discordClient.postWarning(&Webhook{
+		Embeds: []Embed{
+			{
+				Title:       fmt.Sprintf("%s detection failed for record %s.%s - %s record left untouched", family, record, domain, rrsetTypeOf(family)),
+				Description: failure.Error(),
+			},
+		},
+	})
+	return errors.Wrap(err, "failed to send message to discord")
+}
 
-	dnsRecords, err := dyndns.gandiClient.get(domain, record)
+// sync compares resolvedIPs with the DNS records of record. If necessary, it
+// updates the records and notifies Discord. The records of the families in
+// stale are handled according to the --stale-records policy, a missing
+// record according to --create-if-missing.
+func (dyndns *DynDNS) sync(domain string, record string, resolvedIPs *IPAddrs, stale ipFamily, ttl int, alwaysNotify bool) error {
+	dnsRecords, err := dyndns.provider.List(domain, record)
+	if errors.Is(err, errRecordNotFound) {
+		dnsRecords, err = nil, nil
+	}
 	if err != nil {
 		return err
 	}
 
-	needUpdate := dyndns.matchIPs(resolvedIPs, dnsRecords)
+	// only the rrsets of the managed families count: with --ipv4-only, a
+	// record with an AAAA alone is missing its A
+	missing := len(recordsOf(dnsRecords, dyndns.families)) == 0
+	if missing {
+		if err := dyndns.checkCreate(domain, record, resolvedIPs); err != nil {
+			return err
+		}
+	}
+
+	staleRecords := recordsOf(dnsRecords, stale)
+	if dyndns.staleRecords == staleWarn {
+		for _, staleRecord := range staleRecords {
+			err := dyndns.warnStaleRecord(domain, record, staleRecord)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if dyndns.staleRecords != staleDelete {
+		staleRecords = nil
+	}
+
+	var (
+		records    []*rrset
+		needUpdate bool
+	)
+	if dyndns.managedValues {
+		records, staleRecords = dyndns.buildManagedRecords(record+"."+domain, resolvedIPs, dnsRecords, staleRecords, ttl)
+		needUpdate = len(records) > 0
+	} else {
+		records = dyndns.buildRecords(resolvedIPs, dnsRecords, ttl)
+		for _, staleRecord := range staleRecords {
+			records = append(records, &rrset{Type: staleRecord.Type})
+		}
+		needUpdate = missing || dyndns.matchIPs(resolvedIPs, dnsRecords) || len(staleRecords) > 0
+	}
 
 	if !needUpdate {
 		log.Println("IP address(es) match - no further action")
 
+		if dyndns.managedValues {
+			if err := dyndns.savePublished(record+"."+domain, resolvedIPs, staleRecords); err != nil {
+				return err
+			}
+		}
+
 		if alwaysNotify {
 			err := dyndns.discordClient.postInfo(&Webhook{
 				Embeds: []Embed{
@@ -118,18 +336,96 @@ func (dyndns *DynDNS) execute(domain string, record string, ttl int, alwaysNotif
 		return nil
 	}
 
-	err = dyndns.gandiClient.put(domain, record, []*net.IP{resolvedIPs.V4, resolvedIPs.V6}, ttl)
+	// an rrset without values is deleted
+	upserts := make([]*rrset, 0, len(records))
+	deletes := make([]string, 0, len(records))
+	for _, set := range records {
+		if len(set.Values) == 0 {
+			deletes = append(deletes, set.Type)
+		} else {
+			upserts = append(upserts, set)
+		}
+	}
+
+	err = dyndns.provider.Update(domain, record, upserts, deletes)
 	if err != nil {
 		return err
 	}
 
+	if dyndns.managedValues {
+		if err := dyndns.savePublished(record+"."+domain, resolvedIPs, staleRecords); err != nil {
+			return err
+		}
+	}
+
+	if missing {
+		log.Printf("DNS record for %s.%s created\n", record, domain)
+		return dyndns.notifyCreated(domain, record, resolvedIPs.values())
+	}
+
 	log.Printf("DNS record for %s.%s updated\n", record, domain)
 
-	err = dyndns.notifyDiscord(domain, record, resolvedIPs.values())
+	err = dyndns.notifyDiscord(domain, record, resolvedIPs.values(), staleRecords)
 	return err
 }
 
-func (dyndns *DynDNS) notifyDiscord(domain string, record string, ips []*net.IP) error {
+func (dyndns *DynDNS) warnStaleRecord(domain string, record string, staleRecord *rrset) error {
+	log.Printf("warning: stale %s record for %s.%s: %s\n", staleRecord.Type, record, domain, staleRecord.Values)
+
+	err := dyndns.discordClient.postWarning(&Webhook{
+		Embeds: []Embed{
+			{
+				Title: fmt.Sprintf("Stale %s record for %s.%s", staleRecord.Type, record, domain),
+				Description: fmt.Sprintf(
+					"No address of this family was found, but the record still points to %s. To delete it, use `--stale-records delete`. To silence this warning, use `--stale-records keep`",
+					staleRecord.Values,
+				),
+			},
+		},
+	})
+	return errors.Wrap(err, "failed to send message to discord")
+}
+
+// checkCreate applies the --create-if-missing policy to record, which has no
+// rrset of the managed families yet
+func (dyndns *DynDNS) checkCreate(domain string, record string, resolvedIPs *IPAddrs) error {
+	types := "A nor AAAA"
+	if dyndns.families != familyBoth {
+		types = rrsetTypeOf(dyndns.families)
+	}
+
+	switch dyndns.createIfMissing {
+	case createFail:
+		return errors.Wrapf(errRecordNotFound, "%s.%s has no %s record, create it or use `--create-if-missing create`", record, domain, types)
+	case createAsk:
+		ok, err := confirm(fmt.Sprintf("%s.%s has no %s record, create it with %s?", record, domain, types, resolvedIPs))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.Wrapf(errRecordNotFound, "%s.%s has no %s record and its creation was declined", record, domain, types)
+		}
+	}
+
+	log.Printf("%s.%s has no %s record, creating it\n", record, domain, types)
+	return nil
+}
+
+func (dyndns *DynDNS) notifyCreated(domain string, record string, ips []*net.IP) error {
+	err := dyndns.discordClient.postSuccess(&Webhook{
+		Embeds: []Embed{
+			{
+				Title:       fmt.Sprintf("DNS record %s.%s created", record, domain),
+				Description: fmt.Sprintf("It did not exist yet. See [%s](%s)", dyndns.provider.Name(), dyndns.provider.ConsoleURL(domain)),
+				Fields:      ipFields(ips),
+			},
+		},
+	})
+	return errors.Wrap(err, "failed to post success message to Discord")
+}
+
+// ipFields lists ips in the fields of an embed
+func ipFields(ips []*net.IP) []Field {
 	fields := make([]Field, 0, len(ips))
 	for _, ip := range ips {
 		field := &Field{Inline: true, Value: ip.String()}
@@ -142,12 +438,21 @@ func (dyndns *DynDNS) notifyDiscord(domain string, record string, ips []*net.IP)
 
 		fields = append(fields, *field)
 	}
+	return fields
+}
+
+func (dyndns *DynDNS) notifyDiscord(domain string, record string, ips []*net.IP, deleted []*rrset) error {
+	fields := ipFields(ips)
+
+	for _, record := range deleted {
+		fields = append(fields, Field{Inline: true, Name: record.Type + " deleted", Value: fmt.Sprint(record.Values)})
+	}
 
 	err := dyndns.discordClient.postSuccess(&Webhook{
 		Embeds: []Embed{
 			{
 				Title:       fmt.Sprintf("DNS record for %s.%s updated with the new IP adresses", record, domain),
-				Description: fmt.Sprintf("See [Gandi Live DNS](https://admin.gandi.net/domain/%s/records)", domain),
+				Description: fmt.Sprintf("See [%s](%s)", dyndns.provider.Name(), dyndns.provider.ConsoleURL(domain)),
 				Fields:      fields,
 			},
 		},
@@ -155,14 +460,14 @@ func (dyndns *DynDNS) notifyDiscord(domain string, record string, ips []*net.IP)
 	return errors.Wrap(err, "failed to post success message to Discord")
 }
 
-func (dyndns *DynDNS) matchIPs(resolvedIPs *IPAddrs, dnsRecords []*domainRecord) bool {
+func (dyndns *DynDNS) matchIPs(resolvedIPs *IPAddrs, dnsRecords []*rrset) bool {
 	ipsFromDNS := make([]*net.IP, 0, 2)
 
 	var foundIPV4 bool
 	var foundIPV6 bool
 
 	for _, records := range dnsRecords {
-		for _, rrsetValue := range records.RrsetValues {
+		for _, rrsetValue := range records.Values {
 			ipsFromDNS = append(ipsFromDNS, rrsetValue)
 
 			if resolvedIPs.V4 != nil && rrsetValue.Equal(*resolvedIPs.V4) {
@@ -175,5 +480,144 @@ func (dyndns *DynDNS) matchIPs(resolvedIPs *IPAddrs, dnsRecords []*domainRecord)
 
 	log.Printf("IP(s) from DNS:        %s", ipsFromDNS)
 
-	return !foundIPV4 || !foundIPV6
+	// only compare the families we have an address for
+	return (resolvedIPs.V4 != nil && !foundIPV4) || (resolvedIPs.V6 != nil && !foundIPV6)
+}
+
+// buildRecords returns the rrsets to write: the ones of the resolved
+// addresses missing from the DNS records. The other rrsets are left untouched.
+func (dyndns *DynDNS) buildRecords(resolvedIPs *IPAddrs, dnsRecords []*rrset, ttl int) []*rrset {
+	records := make([]*rrset, 0, 2)
+
+	for _, ip := range resolvedIPs.values() {
+		if containsIP(dnsRecords, ip) {
+			continue
+		}
+		records = append(records, &rrset{Type: rrsetType(ip), TTL: ttl, Values: []*net.IP{ip}})
+	}
+
+	return records
+}
+
+// containsIP reports whether ip is a value of its rrset in dnsRecords
+func containsIP(dnsRecords []*rrset, ip *net.IP) bool {
+	for _, record := range dnsRecords {
+		if record.Type != rrsetType(ip) {
+			continue
+		}
+		for _, value := range record.Values {
+			if value.Equal(*ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// buildManagedRecords returns the rrsets to write in managed value mode: in
+// each rrset, the value published by the previous run is replaced by the
+// resolved address and the other values are kept. The previous value is
+// removed from the stale rrsets, it is returned as deleted.
+func (dyndns *DynDNS) buildManagedRecords(fqdn string, resolvedIPs *IPAddrs, dnsRecords []*rrset, stale []*rrset, ttl int) (records []*rrset, deleted []*rrset) {
+	previous := dyndns.state.published(fqdn)
+
+	for _, family := range familyBoth.split() {
+		ip, previousIP := resolvedIPs.V4, previous.V4
+		if family == familyV6 {
+			ip, previousIP = resolvedIPs.V6, previous.V6
+		}
+
+		isStale := len(recordsOf(stale, family)) > 0
+		if ip == nil && (!isStale || previousIP == nil) {
+			continue
+		}
+
+		current := &rrset{Type: rrsetTypeOf(family), TTL: ttl}
+		if currents := recordsOf(dnsRecords, family); len(currents) > 0 {
+			current = currents[0]
+		}
+
+		values := make([]*net.IP, 0, len(current.Values)+1)
+		for _, value := range current.Values {
+			if (previousIP != nil && value.Equal(*previousIP)) || (ip != nil && value.Equal(*ip)) {
+				continue
+			}
+			values = append(values, value)
+		}
+
+		if ip != nil {
+			values = append(values, ip)
+		}
+
+		if len(values) == len(current.Values) && containsAll(current.Values, values) {
+			continue
+		}
+
+		if ip == nil {
+			records = append(records, &rrset{Type: current.Type, TTL: current.TTL, Values: values})
+			deleted = append(deleted, &rrset{Type: current.Type, Values: []*net.IP{previousIP}})
+		} else {
+			records = append(records, &rrset{Type: current.Type, TTL: ttl, Values: values})
+		}
+	}
+
+	return records, deleted
+}
+
+// savePublished remembers the values published in the rrsets of fqdn for the
+// next runs in managed value mode
+func (dyndns *DynDNS) savePublished(fqdn string, resolvedIPs *IPAddrs, deleted []*rrset) error {
+	previous := dyndns.state.published(fqdn)
+	published := &published{V4: previous.V4, V6: previous.V6}
+
+	if resolvedIPs.V4 != nil {
+		published.V4 = resolvedIPs.V4
+	}
+	if resolvedIPs.V6 != nil {
+		published.V6 = resolvedIPs.V6
+	}
+	for _, record := range deleted {
+		if record.Type == "A" {
+			published.V4 = nil
+		} else {
+			published.V6 = nil
+		}
+	}
+
+	if published.equal(previous) {
+		return nil
+	}
+
+	dyndns.state.setPublished(fqdn, published)
+	return dyndns.state.save(dyndns.stateFile)
+}
+
+// containsAll reports whether every ip of ips is in values
+func containsAll(values []*net.IP, ips []*net.IP) bool {
+	for _, ip := range ips {
+		found := false
+		for _, value := range values {
+			if value.Equal(*ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// recordsOf returns the A and/or AAAA rrsets matching families
+func recordsOf(dnsRecords []*rrset, families ipFamily) []*rrset {
+	records := make([]*rrset, 0, 2)
+	for _, record := range dnsRecords {
+		for _, family := range families.split() {
+			if record.Type == rrsetTypeOf(family) {
+				records = append(records, record)
+			}
+		}
+	}
+	return records
 }
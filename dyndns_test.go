@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+type fakeProvider struct {
+	records []*rrset
+	upserts []*rrset
+	deletes []string
+}
+
+func (p *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (p *fakeProvider) ConsoleURL(domain string) string {
+	return "https://dns.example.net/" + domain
+}
+
+func (p *fakeProvider) List(domain string, name string) ([]*rrset, error) {
+	return p.records, nil
+}
+
+func (p *fakeProvider) Update(domain string, name string, upserts []*rrset, deletes []string) error {
+	p.upserts = append(p.upserts, upserts...)
+	p.deletes = append(p.deletes, deletes...)
+	return nil
+}
+
+func TestMatchIPsSingleStack(t *testing.T) {
+	v4 := net.ParseIP("109.215.101.49")
+	v6 := net.ParseIP("2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82")
+	otherV6 := net.ParseIP("2a02:cb19:96a:7c00:13b0:5ba3:16ae:6c82")
+
+	dnsRecords := []*rrset{
+		{Type: "A", Values: []*net.IP{&v4}},
+		{Type: "AAAA", Values: []*net.IP{&otherV6}},
+	}
+
+	dyndns := &DynDNS{families: familyBoth}
+
+	if dyndns.matchIPs(&IPAddrs{V4: &v4}, dnsRecords) {
+		t.Errorf("IPv4-only host: expected no update when the A record matches")
+	}
+	if !dyndns.matchIPs(&IPAddrs{V4: &v4, V6: &v6}, dnsRecords) {
+		t.Errorf("dual-stack host: expected an update when the AAAA record differs")
+	}
+}
+
+func TestBuildRecords(t *testing.T) {
+	v4 := net.ParseIP("109.215.101.49")
+	v6 := net.ParseIP("2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82")
+	oldV4 := net.ParseIP("108.215.101.49")
+
+	dnsRecords := []*rrset{
+		{Type: "A", TTL: 300, Values: []*net.IP{&oldV4}},
+		{Type: "AAAA", TTL: 300, Values: []*net.IP{&v6}},
+	}
+
+	dyndns := &DynDNS{families: familyBoth}
+
+	records := dyndns.buildRecords(&IPAddrs{V4: &v4, V6: &v6}, dnsRecords, 3600)
+	if len(records) != 1 || records[0].Type != "A" || !records[0].Values[0].Equal(v4) || records[0].TTL != 3600 {
+		t.Errorf("expected only the A record to be updated, got %+v", records)
+	}
+
+	records = dyndns.buildRecords(&IPAddrs{V4: &oldV4}, dnsRecords, 3600)
+	if len(records) != 0 {
+		t.Errorf("expected no update when the A record matches, got %+v", records)
+	}
+}
+
+func TestBuildManagedRecords(t *testing.T) {
+	v4 := net.ParseIP("109.215.101.49")
+	oldV4 := net.ParseIP("108.215.101.49")
+	backupV4 := net.ParseIP("203.0.113.7")
+	backupV6 := net.ParseIP("2001:db8::7")
+	oldV6 := net.ParseIP("2a02:cb19:96a:7c00:13b0:5ba3:16ae:6c82")
+
+	dnsRecords := []*rrset{
+		{Type: "A", TTL: 300, Values: []*net.IP{&backupV4, &oldV4}},
+		{Type: "AAAA", TTL: 300, Values: []*net.IP{&oldV6, &backupV6}},
+	}
+
+	s := &state{}
+	s.setPublished("www.example.com", &published{V4: &oldV4, V6: &oldV6})
+	dyndns := &DynDNS{families: familyBoth, managedValues: true, state: s}
+
+	records, deleted := dyndns.buildManagedRecords("www.example.com", &IPAddrs{V4: &v4}, dnsRecords, nil, 3600)
+	if len(records) != 1 || len(deleted) != 0 {
+		t.Fatalf("expected only the A record to be updated, got %+v deleted=%+v", records, deleted)
+	}
+	if values := records[0].Values; len(values) != 2 || !values[0].Equal(backupV4) || !values[1].Equal(v4) {
+		t.Errorf("expected the backup value to be kept and %s to replace %s, got %s", v4, oldV4, values)
+	}
+
+	// the AAAA rrset is stale, only the published value is removed
+	records, deleted = dyndns.buildManagedRecords("www.example.com", &IPAddrs{V4: &oldV4}, dnsRecords, dnsRecords[1:], 3600)
+	if len(records) != 1 || records[0].Type != "AAAA" || len(records[0].Values) != 1 || !records[0].Values[0].Equal(backupV6) {
+		t.Fatalf("expected the AAAA record to only keep the backup value, got %+v", records)
+	}
+	if len(deleted) != 1 || !deleted[0].Values[0].Equal(oldV6) {
+		t.Errorf("expected %s to be reported as deleted, got %+v", oldV6, deleted)
+	}
+
+	// nothing published yet: the address is added next to the other values
+	dyndns.state = &state{}
+	records, _ = dyndns.buildManagedRecords("www.example.com", &IPAddrs{V4: &v4}, dnsRecords, nil, 3600)
+	if len(records) != 1 || len(records[0].Values) != 3 {
+		t.Errorf("expected %s to be added to the A record, got %+v", v4, records)
+	}
+
+	records, _ = dyndns.buildManagedRecords("www.example.com", &IPAddrs{V4: &oldV4}, dnsRecords, nil, 3600)
+	if len(records) != 0 {
+		t.Errorf("expected no update when the address is already a value, got %+v", records)
+	}
+}
+
+func TestSavePublished(t *testing.T) {
+	v4 := net.ParseIP("109.215.101.49")
+	oldV6 := net.ParseIP("2a02:cb19:96a:7c00:13b0:5ba3:16ae:6c82")
+
+	s := &state{}
+	s.setPublished("www.example.com", &published{V6: &oldV6})
+	dyndns := &DynDNS{state: s, stateFile: filepath.Join(t.TempDir(), "state.json")}
+
+	err := dyndns.savePublished("www.example.com", &IPAddrs{V4: &v4}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	saved, err := loadState(dyndns.stateFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p := saved.published("www.example.com")
+	if !equalIPs(p.V4, &v4) || !equalIPs(p.V6, &oldV6) {
+		t.Errorf("got %+v, want the new IPv4 and the previous IPv6", p)
+	}
+}
+
+func TestCheckCreate(t *testing.T) {
+	v4 := net.ParseIP("109.215.101.49")
+
+	dyndns := &DynDNS{families: familyBoth}
+	if err := dyndns.checkCreate("example.com", "www", &IPAddrs{V4: &v4}); err != nil {
+		t.Errorf("expected the record to be created by default, got %v", err)
+	}
+
+	dyndns.createIfMissing = createFail
+	err := dyndns.checkCreate("example.com", "www", &IPAddrs{V4: &v4})
+	if !errors.Is(err, errRecordNotFound) {
+		t.Errorf("got %v, want a record not found error", err)
+	}
+
+	// with --ipv4-only, an AAAA alone does not make the record exist
+	v6 := net.ParseIP("2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82")
+	provider := &fakeProvider{records: []*rrset{{Type: "AAAA", TTL: 3600, Values: []*net.IP{&v6}}}}
+	dyndns = &DynDNS{provider: provider, families: familyV4, createIfMissing: createFail}
+	err = dyndns.sync("example.com", "www", &IPAddrs{V4: &v4}, 0, 3600, false)
+	if !errors.Is(err, errRecordNotFound) || len(provider.upserts) != 0 {
+		t.Errorf("got %v upserts=%+v, want a record not found error", err, provider.upserts)
+	}
+}
+
+func TestExecuteFailedFamily(t *testing.T) {
+	colors := make([]int64, 0, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		webhook := &Webhook{}
+		if err := json.NewDecoder(r.Body).Decode(webhook); err == nil && len(webhook.Embeds) > 0 {
+			colors = append(colors, webhook.Embeds[0].Color)
+		}
+	}))
+	defer server.Close()
+
+	v4 := net.ParseIP("109.215.101.49")
+	oldV4 := net.ParseIP("108.215.101.49")
+	v6 := net.ParseIP("2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82")
+
+	partial := &IPAddrs{V4: &v4}
+	partial.fail(familyV6, errors.New("status=503"))
+
+	provider := &fakeProvider{records: []*rrset{
+		{Type: "A", TTL: 3600, Values: []*net.IP{&oldV4}},
+		{Type: "AAAA", TTL: 3600, Values: []*net.IP{&v6}},
+	}}
+
+	dyndns := &DynDNS{
+		provider:      provider,
+		discordClient: &discordClient{WebhookURL: server.URL},
+		resolver:      &fakeResolver{name: "fake", ipAddrs: partial},
+		policy:        &ipPolicy{onReject: onRejectAbort},
+		families:      familyBoth,
+		staleRecords:  staleDelete,
+	}
+
+	err := dyndns.execute("example.com", "www", 3600, false)
+
+	var degradedErr *degradedError
+	if !errors.As(err, &degradedErr) || degradedErr.failed != familyV6 {
+		t.Fatalf("got %v, want IPv6 degraded", err)
+	}
+	if len(provider.upserts) != 1 || provider.upserts[0].Type != "A" || len(provider.deletes) != 0 {
+		t.Errorf("expected only the A record to be updated, got upserts=%+v deletes=%v", provider.upserts, provider.deletes)
+	}
+	if len(colors) != 2 || colors[0] != 16098851 || colors[1] != 5747840 {
+		t.Errorf("got embed colors %v, want a warning then a success", colors)
+	}
+}
+
+func TestExecuteLANHosts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	v4 := net.ParseIP("109.215.101.49")
+	v6 := net.ParseIP("2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82")
+	oldV4 := net.ParseIP("108.215.101.49")
+	oldV6 := net.ParseIP("2a02:cb19:96a:7c00:13b0:5ba3:16ae:6c82")
+
+	newDynDNS := func(ipAddrs *IPAddrs) (*DynDNS, *fakeProvider) {
+		provider := &fakeProvider{records: []*rrset{
+			{Type: "A", TTL: 3600, Values: []*net.IP{&oldV4}},
+			{Type: "AAAA", TTL: 3600, Values: []*net.IP{&oldV6}},
+		}}
+		return &DynDNS{
+			provider:      provider,
+			discordClient: &discordClient{WebhookURL: server.URL},
+			resolver:      &fakeResolver{name: "fake", ipAddrs: ipAddrs},
+			policy:        &ipPolicy{onReject: onRejectSkip},
+			families:      familyBoth,
+			lanHosts:      []*lanHost{{"nas", net.ParseIP("::1:2:3:4")}},
+			prefixLength:  64,
+		}, provider
+	}
+
+	dyndns, provider := newDynDNS(&IPAddrs{V4: &v4, V6: &v6})
+	if err := dyndns.execute("example.com", "www", 3600, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(provider.upserts) != 3 || ipString(provider.upserts[2].Values[0]) != "2a01:cb19:96a:7c00:1:2:3:4" {
+		t.Errorf("expected the A, AAAA and LAN host AAAA records to be updated, got %+v", provider.upserts)
+	}
+
+	// the IPv6 is a documentation address, rejected in skip mode
+	doc := net.ParseIP("2001:db8::1")
+	dyndns, provider = newDynDNS(&IPAddrs{V4: &v4, V6: &doc})
+	if err := dyndns.execute("example.com", "www", 3600, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(provider.upserts) != 1 || provider.upserts[0].Type != "A" {
+		t.Errorf("expected only the A record to be updated, got %+v", provider.upserts)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// execTimeout is a variable so that the tests can shorten it
+var execTimeout = 10 * time.Second
+
+// execResolver runs a user-provided command, which prints the current
+// address(es) on stdout, separated by spaces or newlines
+type execResolver struct {
+	command string
+	args    []string
+}
+
+var _ IPResolver = (*execResolver)(nil)
+
+// newExecResolver parses command, split on spaces without any shell
+// interpretation
+func newExecResolver(command string) (*execResolver, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("missing command, expected exec:<command> [<argument>...]")
+	}
+
+	return &execResolver{fields[0], fields[1:]}, nil
+}
+
+func (r *execResolver) Name() string {
+	return "exec:" + r.command
+}
+
+func (r *execResolver) Resolve(families ipFamily) (*IPAddrs, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(r.command, r.args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// the command runs in its own process group, so that the processes it
+	// forks are killed along with it on timeout. Otherwise they would keep
+	// stdout open, and Wait would block until they exit.
+	setProcessGroup(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("%s failed: %s", r.command, err)
+	}
+
+	timer := time.AfterFunc(execTimeout, func() {
+		killProcessGroup(cmd)
+	})
+	err := cmd.Wait()
+
+	// the timer already fired if it cannot be stopped
+	if !timer.Stop() {
+		return nil, fmt.Errorf("%s timed out after %s", r.command, execTimeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %s stderr=%s", r.command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	ipAddrs, err := parseExecOutput(stdout.String())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", r.command, err)
+	}
+
+	ipAddrs = ipAddrs.only(families)
+	if ipAddrs.V4 == nil && ipAddrs.V6 == nil {
+		return nil, &noAddressError{fmt.Sprintf("%s printed no %s address", r.command, families)}
+	}
+
+	return ipAddrs, nil
+}
+
+// parseExecOutput classifies the addresses of output by family. Anything
+// which is not an address is an error, and so is a second address of the
+// same family.
+func parseExecOutput(output string) (*IPAddrs, error) {
+	ipAddrs := &IPAddrs{}
+
+	for _, field := range strings.Fields(output) {
+		ip := net.ParseIP(field)
+		if ip == nil {
+			return nil, fmt.Errorf("unexpected output %q, expected IP addresses", field)
+		}
+
+		family := familyV6
+		if ip.To4() != nil {
+			family = familyV4
+		}
+
+		if ipAddrs.families().has(family) {
+			return nil, fmt.Errorf("more than one %s address in the output", family)
+		}
+
+		if err := ipAddrs.set(family, ip); err != nil {
+			return nil, err
+		}
+	}
+
+	return ipAddrs, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseExecOutput(t *testing.T) {
+	tests := []struct {
+		output  string
+		want    string
+		wantErr string
+	}{
+		{"109.215.101.49\n", "[109.215.101.49]", ""},
+		{"109.215.101.49 2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82\n", "[109.215.101.49 2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82]", ""},
+		{"2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82\n109.215.101.49\n", "[109.215.101.49 2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82]", ""},
+		{"", "[]", ""},
+		{"WAN: 109.215.101.49", "", "unexpected output"},
+		{"109.215.101.49 109.215.101.50", "", "more than one IPv4"},
+	}
+
+	for _, tt := range tests {
+		ipAddrs, err := parseExecOutput(tt.output)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%q: got %v, want an error containing %q", tt.output, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.output, err)
+			continue
+		}
+		if ipAddrs.String() != tt.want {
+			t.Errorf("%q: got %s, want %s", tt.output, ipAddrs, tt.want)
+		}
+	}
+}
+
+func TestExecResolver(t *testing.T) {
+	resolver, err := newIPResolver("exec:echo 109.215.101.49 2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ipAddrs, err := resolver.Resolve(familyV6)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ipAddrs.V4 != nil || ipString(ipAddrs.V6) != "2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82" {
+		t.Errorf("got %s, want [ 2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82]", ipAddrs)
+	}
+
+	resolver, _ = newIPResolver("exec:false")
+	if _, err := resolver.Resolve(familyBoth); err == nil || !strings.Contains(err.Error(), "failed") {
+		t.Errorf("got %v, want a failure", err)
+	}
+
+	if _, err := newIPResolver("exec:"); err == nil {
+		t.Errorf("expected an error for a missing command")
+	}
+}
+
+func TestExecResolverTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+
+	// the script forks a process keeping stdout open after the script is
+	// killed
+	script := filepath.Join(t.TempDir(), "fork.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\nsleep 30 &\nwait\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(timeout time.Duration) { execTimeout = timeout }(execTimeout)
+	execTimeout = 100 * time.Millisecond
+
+	resolver, _ := newIPResolver("exec:" + script)
+
+	start := time.Now()
+	_, err := resolver.Resolve(familyBoth)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("got %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("took %s, want the forked process to be killed on timeout", elapsed)
+	}
+}
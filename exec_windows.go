@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup only kills the command, Windows has no process group to
+// signal
+func killProcessGroup(cmd *exec.Cmd) {
+	_ = cmd.Process.Kill()
+}
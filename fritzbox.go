@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	fritzboxDefaultHost = "fritz.box:49000"
+	fritzboxControlURL  = "/upnp/control/wanipconnection1"
+	fritzboxService     = "urn:dslforum-org:service:WANIPConnection:1"
+)
+
+// fritzboxResolver reads the external addresses of a FRITZ!Box over TR-064.
+// When the FRITZBOX_PASSWORD environment variable is set, the requests are
+// authenticated with HTTP Digest.
+type fritzboxResolver struct {
+	host        string
+	credentials *url.Userinfo
+}
+
+var _ IPResolver = (*fritzboxResolver)(nil)
+
+func newFritzboxResolver(host string, opts url.Values) (*fritzboxResolver, error) {
+	if host == "" {
+		host = fritzboxDefaultHost
+	}
+
+	var credentials *url.Userinfo
+	if password := os.Getenv("FRITZBOX_PASSWORD"); password != "" {
+		credentials = url.UserPassword(opts.Get("user"), password)
+	}
+
+	return &fritzboxResolver{host, credentials}, nil
+}
+
+func (r *fritzboxResolver) Name() string {
+	return "fritzbox:" + r.host
+}
+
+func (r *fritzboxResolver) Resolve(families ipFamily) (*IPAddrs, error) {
+	controlURL := "http://" + r.host + fritzboxControlURL
+
+	return resolveFamilies(families, func(family ipFamily) (net.IP, error) {
+		var (
+			action   = "GetExternalIPAddress"
+			response = struct {
+				NewExternalIPAddress   string
+				NewExternalIPv6Address string
+			}{}
+		)
+		if family == familyV6 {
+			// TR-064 spells the AVM extensions X_AVM-DE_, unlike the IGD
+			// service at /igdupnp/control/WANIPConn1 which spells them
+			// X_AVM_DE_
+			action = "X_AVM-DE_GetExternalIPv6Address"
+		}
+
+		err := soapCall(defaultHTTP, controlURL, fritzboxService, action, r.credentials, &response)
+		if err != nil {
+			return nil, err
+		}
+
+		value := response.NewExternalIPAddress
+		if family == familyV6 {
+			value = response.NewExternalIPv6Address
+		}
+
+		ip := net.ParseIP(strings.TrimSpace(value))
+		if ip == nil {
+			return nil, &noAddressError{fmt.Sprintf("%s: no external address", action)}
+		}
+		return ip, nil
+	})
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestFritzboxResolverDigestAuth(t *testing.T) {
+	const realm, nonce = "F!Box SOAP-Auth", "4F1C9E2A8B3D7C60"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != fritzboxControlURL {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+
+		params := parseDigestChallenge(strings.TrimPrefix(r.Header.Get("Authorization"), "Digest "))
+		ha1 := md5Hex("admin:" + realm + ":secret")
+		ha2 := md5Hex(r.Method + ":" + params["uri"])
+		want := md5Hex(ha1 + ":" + nonce + ":" + params["nc"] + ":" + params["cnonce"] + ":" + params["qop"] + ":" + ha2)
+		if params["username"] != "admin" || params["response"] != want {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Digest realm="%s", nonce="%s", algorithm=MD5, qop="auth"`, realm, nonce))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// the actions of the wanipconnSCPD of the TR-064 service, a FRITZ!Box
+		// answers an Invalid Action fault to the others
+		action := strings.TrimSuffix(strings.TrimPrefix(r.Header.Get("SOAPAction"), `"`+fritzboxService+"#"), `"`)
+		body, _ := io.ReadAll(r.Body)
+		if !strings.Contains(string(body), "<u:"+action+" ") {
+			t.Errorf("SOAPAction %s does not match the body %s", action, body)
+		}
+
+		switch action {
+		case "GetExternalIPAddress":
+			fmt.Fprint(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`+
+				`<u:GetExternalIPAddressResponse xmlns:u="`+fritzboxService+`">`+
+				`<NewExternalIPAddress>109.215.101.49</NewExternalIPAddress>`+
+				`</u:GetExternalIPAddressResponse></s:Body></s:Envelope>`)
+		case "X_AVM-DE_GetExternalIPv6Address":
+			fmt.Fprint(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`+
+				`<u:X_AVM-DE_GetExternalIPv6AddressResponse xmlns:u="`+fritzboxService+`">`+
+				`<NewExternalIPv6Address>2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82</NewExternalIPv6Address>`+
+				`<NewPrefixLength>64</NewPrefixLength>`+
+				`</u:X_AVM-DE_GetExternalIPv6AddressResponse></s:Body></s:Envelope>`)
+		default:
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>`+
+				`<s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>`+
+				`<detail><UPnPError xmlns="urn:dslforum-org:control-1-0"><errorCode>401</errorCode>`+
+				`<errorDescription>Invalid Action</errorDescription></UPnPError></detail>`+
+				`</s:Fault></s:Body></s:Envelope>`)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	resolver := &fritzboxResolver{host, url.UserPassword("admin", "secret")}
+	ipAddrs, err := resolver.Resolve(familyBoth)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ipString(ipAddrs.V4) != "109.215.101.49" || ipString(ipAddrs.V6) != "2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82" {
+		t.Errorf("got %s, want [109.215.101.49 2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82]", ipAddrs)
+	}
+
+	resolver = &fritzboxResolver{host, url.UserPassword("admin", "wrong")}
+	if _, err := resolver.Resolve(familyV4); err == nil || !strings.Contains(err.Error(), "unauthorized") {
+		t.Errorf("got %v, want an unauthorized error", err)
+	}
+}
+
+func TestParseDigestChallenge(t *testing.T) {
+	params := parseDigestChallenge(`realm="F!Box, SOAP", nonce="abc", qop="auth", algorithm=MD5`)
+
+	want := map[string]string{"realm": "F!Box, SOAP", "nonce": "abc", "qop": "auth", "algorithm": "MD5"}
+	for key, value := range want {
+		if params[key] != value {
+			t.Errorf("%s: got %q, want %q", key, params[key], value)
+		}
+	}
+}
@@ -7,43 +7,41 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
+const gandiAPI = "https://api.gandi.net/v5/livedns"
+
 type gandiClient struct {
 	Token string
 }
 
-// domainRecord represents a DNS Record
+var _ DNSProvider = (*gandiClient)(nil)
+
+// domainRecord represents a DNS Record. Its values are strings since the
+// rrsets of other types than A and AAAA hold names or text.
 type domainRecord struct {
-	RrsetType   string    `json:"rrset_type,omitempty"`
-	RrsetTTL    int       `json:"rrset_ttl,omitempty"`
-	RrsetName   string    `json:"rrset_name,omitempty"`
-	RrsetHref   string    `json:"rrset_href,omitempty"`
-	RrsetValues []*net.IP `json:"rrset_values,omitempty"`
+	RrsetType   string   `json:"rrset_type,omitempty"`
+	RrsetTTL    int      `json:"rrset_ttl,omitempty"`
+	RrsetName   string   `json:"rrset_name,omitempty"`
+	RrsetHref   string   `json:"rrset_href,omitempty"`
+	RrsetValues []string `json:"rrset_values,omitempty"`
 }
 
-func (c *gandiClient) get(domain string, record string) ([]*domainRecord, error) {
-	url := fmt.Sprintf("https://api.gandi.net/v5/livedns/domains/%s/records/%s", domain, record)
-
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	req.Header.Set("Authorization", "ApiKey "+c.Token)
-	req.Header.Set("Content-type", "application/json")
-
-	res, err := defaultHTTP.Do(req)
+func (c *gandiClient) Name() string {
+	return "Gandi Live DNS"
+}
 
-	if err != nil {
-		return nil, err
-	}
-	defer res.Body.Close()
+func (c *gandiClient) ConsoleURL(domain string) string {
+	return fmt.Sprintf("https://admin.gandi.net/domain/%s/records", domain)
+}
 
-	body, err := io.ReadAll(res.Body)
+func (c *gandiClient) List(domain string, name string) ([]*rrset, error) {
+	url := fmt.Sprintf("%s/domains/%s/records/%s", gandiAPI, domain, name)
 
+	body, err := c.do(http.MethodGet, url, nil, domain, name, errDomainNotFound)
 	if err != nil {
 		return nil, err
 	}
@@ -51,39 +49,88 @@ func (c *gandiClient) get(domain string, record string) ([]*domainRecord, error)
 	records := make([]*domainRecord, 0)
 	err = json.Unmarshal(body, &records)
 	if err != nil {
-		return nil, errors.Wrapf(err, "failed to get %s/records/%s  response=%s", domain, record, body)
+		return nil, errors.Wrapf(err, "failed to get %s/records/%s  response=%s", domain, name, body)
 	}
 
-	return records, nil
+	return addressRecords(records)
 }
 
-func rrsetType(ip *net.IP) string {
-	if xx := ip.To4(); xx == nil {
-		return "AAAA"
+// addressRecords keeps the A and AAAA rrsets of records, the other types
+// like MX or TXT are not managed by dyndns
+func addressRecords(records []*domainRecord) ([]*rrset, error) {
+	rrsets := make([]*rrset, 0, 2)
+	for _, record := range records {
+		if record.RrsetType != "A" && record.RrsetType != "AAAA" {
+			continue
+		}
+
+		set := &rrset{Type: record.RrsetType, TTL: record.RrsetTTL, Values: make([]*net.IP, 0, len(record.RrsetValues))}
+		for _, value := range record.RrsetValues {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid %s record value %q", record.RrsetType, value)
+			}
+			set.Values = append(set.Values, &ip)
+		}
+		rrsets = append(rrsets, set)
 	}
-	return "A"
+	return rrsets, nil
 }
 
-func (c *gandiClient) put(domain string, name string, ips []*net.IP, ttl int) error {
-	record := struct {
-		Items []*domainRecord `json:"items"`
-	}{Items: make([]*domainRecord, 0, 2)}
+// Update writes each rrset through the endpoint of its type, so that the
+// rrsets of the other types are kept
+func (c *gandiClient) Update(domain string, name string, upserts []*rrset, deletes []string) error {
+	for _, set := range upserts {
+		if err := c.upsert(domain, name, set); err != nil {
+			return err
+		}
+	}
 
-	for _, ip := range ips {
-		item := &domainRecord{RrsetTTL: ttl, RrsetValues: []*net.IP{ip}, RrsetType: rrsetType(ip)}
-		record.Items = append(record.Items, item)
+	for _, rrsetType := range deletes {
+		if err := c.delete(domain, name, rrsetType); err != nil {
+			return err
+		}
 	}
 
-	payload, err := json.Marshal(record)
+	return nil
+}
+
+func (c *gandiClient) upsert(domain string, name string, set *rrset) error {
+	values := make([]string, 0, len(set.Values))
+	for _, value := range set.Values {
+		values = append(values, value.String())
+	}
+
+	payload, err := json.Marshal(&domainRecord{RrsetTTL: set.TTL, RrsetValues: values})
 	if err != nil {
 		return err
 	}
 
-	url := fmt.Sprintf("https://api.gandi.net/v5/livedns/domains/%s/records/%s", domain, name)
+	url := fmt.Sprintf("%s/domains/%s/records/%s/%s", gandiAPI, domain, name, set.Type)
+
+	_, err = c.do(http.MethodPut, url, payload, domain, name, errDomainNotFound)
+	return err
+}
 
-	req, err := http.NewRequest(http.MethodPut, url, bytes.NewReader(payload))
+// delete removes the rrset of rrsetType, an rrset already missing is not an
+// error
+func (c *gandiClient) delete(domain string, name string, rrsetType string) error {
+	url := fmt.Sprintf("%s/domains/%s/records/%s/%s", gandiAPI, domain, name, rrsetType)
+
+	_, err := c.do(http.MethodDelete, url, nil, domain, name, errRecordNotFound)
+	if errors.Is(err, errRecordNotFound) {
+		return nil
+	}
+	return err
+}
+
+// do sends an authenticated request to the API about the records of name,
+// and returns the body of the response. An error response is converted into
+// a providerError, notFound is the kind of its 404s.
+func (c *gandiClient) do(method string, url string, payload []byte, domain string, name string, notFound error) ([]byte, error) {
+	req, err := http.NewRequest(method, url, bytes.NewReader(payload))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	req.Header.Set("Authorization", "ApiKey "+c.Token)
@@ -92,19 +139,90 @@ func (c *gandiClient) put(domain string, name string, ips []*net.IP, ttl int) er
 	res, err := defaultHTTP.Do(req)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer res.Body.Close()
 
 	body, err := io.ReadAll(res.Body)
 
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if res.StatusCode >= 400 {
-		return fmt.Errorf("failed to perform PUT status=%d response=%s", res.StatusCode, body)
+		return nil, c.apiError(res, body, domain, name, notFound)
 	}
 
-	return nil
+	return body, nil
+}
+
+// gandiErrorResponse is the body of the error responses of the API
+type gandiErrorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Cause   string `json:"cause"`
+	Errors  []struct {
+		Location    string `json:"location"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	} `json:"errors"`
+}
+
+// apiError classifies the error response res, and explains how to fix it
+func (c *gandiClient) apiError(res *http.Response, body []byte, domain string, name string, notFound error) error {
+	e := &providerError{provider: c.Name(), status: res.StatusCode, message: strings.TrimSpace(string(body))}
+
+	response := &gandiErrorResponse{}
+	if json.Unmarshal(body, response) == nil && (response.Message != "" || response.Cause != "") {
+		e.message = response.Message
+		if response.Cause != "" && response.Cause != response.Message {
+			e.message = response.Cause + ": " + response.Message
+		}
+		for _, detail := range response.Errors {
+			e.message += fmt.Sprintf("; %s %s: %s", detail.Location, detail.Name, detail.Description)
+		}
+	}
+
+	switch {
+	case res.StatusCode == http.StatusUnauthorized:
+		e.kind = errUnauthorized
+		e.hint = "check that GANDI_TOKEN is a valid personal access token, it may have expired or been revoked"
+	case res.StatusCode == http.StatusForbidden:
+		e.kind = errForbidden
+		e.hint = fmt.Sprintf("the token is not allowed to manage the records of %s, grant it the permission to manage the technical configuration of this domain", domain)
+	case res.StatusCode == http.StatusNotFound && notFound == errRecordNotFound:
+		e.kind = errRecordNotFound
+		e.hint = fmt.Sprintf("%s.%s has no such record, run with `--create-if-missing create` (the default) to create it", name, domain)
+	case res.StatusCode == http.StatusNotFound:
+		e.kind = errDomainNotFound
+		e.hint = fmt.Sprintf("%s is not a domain of the account of the token, or it does not use the LiveDNS nameservers", domain)
+	case res.StatusCode == http.StatusTooManyRequests:
+		e.kind = errRateLimited
+		e.hint = "too many requests to the API, run dyndns less often"
+		if retryAfter := res.Header.Get("Retry-After"); retryAfter != "" {
+			e.hint += fmt.Sprintf(" or retry after %s seconds", retryAfter)
+		}
+	case res.StatusCode >= 500:
+		e.kind = errServerError
+		e.hint = "the API is unavailable, retry later and see https://status.gandi.net"
+	default:
+		return fmt.Errorf("failed to perform %s status=%d response=%s", res.Request.Method, res.StatusCode, body)
+	}
+
+	return e
+}
+
+func rrsetType(ip *net.IP) string {
+	if xx := ip.To4(); xx == nil {
+		return "AAAA"
+	}
+	return "A"
+}
+
+// rrsetTypeOf returns the rrset type holding the addresses of family
+func rrsetTypeOf(family ipFamily) string {
+	if family == familyV4 {
+		return "A"
+	}
+	return "AAAA"
 }
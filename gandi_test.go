@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAddressRecords(t *testing.T) {
+	body := []byte(`[
+		{"rrset_type": "A", "rrset_ttl": 300, "rrset_name": "@", "rrset_values": ["109.215.101.49"]},
+		{"rrset_type": "AAAA", "rrset_ttl": 300, "rrset_name": "@", "rrset_values": ["2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82"]},
+		{"rrset_type": "MX", "rrset_ttl": 10800, "rrset_name": "@", "rrset_values": ["10 spool.mail.gandi.net.", "50 fb.mail.gandi.net."]},
+		{"rrset_type": "TXT", "rrset_ttl": 10800, "rrset_name": "@", "rrset_values": ["\"v=spf1 include:_mailcust.gandi.net ?all\""]}
+	]`)
+
+	records := make([]*domainRecord, 0)
+	if err := json.Unmarshal(body, &records); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rrsets, err := addressRecords(records)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rrsets) != 2 || rrsets[0].Type != "A" || rrsets[1].Type != "AAAA" {
+		t.Fatalf("expected only the A and AAAA rrsets, got %+v", rrsets)
+	}
+	if rrsets[0].TTL != 300 || rrsets[0].Values[0].String() != "109.215.101.49" {
+		t.Errorf("got %+v, want the A rrset of 109.215.101.49", rrsets[0])
+	}
+
+	records[0].RrsetValues = []string{"not an ip"}
+	if _, err := addressRecords(records); err == nil {
+		t.Errorf("expected an error on an invalid A value")
+	}
+}
+
+func TestGandiAPIError(t *testing.T) {
+	c := &gandiClient{}
+
+	tests := []struct {
+		status   int
+		header   http.Header
+		body     string
+		notFound error
+		wantKind error
+		wantMsg  string
+	}{
+		{401, nil, `{"code": 401, "message": "The server could not verify that you authorized to access the document you requested.", "object": "HTTPUnauthorized", "cause": "Unauthorized"}`, errDomainNotFound, errUnauthorized, "GANDI_TOKEN"},
+		{403, nil, `{"code": 403, "message": "Access was denied to this resource.", "object": "HTTPForbidden", "cause": "Forbidden"}`, errDomainNotFound, errForbidden, "not allowed to manage the records of example.com"},
+		{404, nil, `{"code": 404, "message": "The resource could not be found.", "object": "HTTPNotFound", "cause": "Not Found"}`, errDomainNotFound, errDomainNotFound, "example.com is not a domain"},
+		{404, nil, `{"code": 404, "message": "Record not found", "object": "HTTPNotFound", "cause": "Not Found"}`, errRecordNotFound, errRecordNotFound, "--create-if-missing create"},
+		{404, nil, `{"code": 404, "message": "Record not found", "object": "HTTPNotFound", "cause": "Not Found"}`, errDomainNotFound, errDomainNotFound, "example.com is not a domain"},
+		{404, nil, `not json`, errRecordNotFound, errRecordNotFound, "not json"},
+		{429, http.Header{"Retry-After": {"30"}}, `{"code": 429, "message": "Too many requests", "cause": "Too Many Requests"}`, errDomainNotFound, errRateLimited, "retry after 30 seconds"},
+		{503, nil, `<html>Service Unavailable</html>`, errDomainNotFound, errServerError, "status.gandi.net"},
+		{400, nil, `{"code": 400, "message": "Bad Request", "cause": "", "errors": [{"location": "body", "name": "rrset_values", "description": "invalid"}]}`, errDomainNotFound, nil, "status=400"},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodGet, gandiAPI, nil)
+		res := &http.Response{StatusCode: tt.status, Header: tt.header, Request: req}
+		if res.Header == nil {
+			res.Header = http.Header{}
+		}
+
+		err := c.apiError(res, []byte(tt.body), "example.com", "www", tt.notFound)
+
+		if tt.wantKind != nil && !errors.Is(err, tt.wantKind) {
+			t.Errorf("%d %s: got %v, want a %v error", tt.status, tt.body, err, tt.wantKind)
+		}
+		if !strings.Contains(err.Error(), tt.wantMsg) {
+			t.Errorf("%d %s: got %q, want it to contain %q", tt.status, tt.body, err, tt.wantMsg)
+		}
+	}
+}
@@ -4,6 +4,7 @@ go 1.19
 
 require (
 	github.com/confluentinc/bincover v0.2.0
+	github.com/gosnmp/gosnmp v1.32.0
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.8.1
 	go.mlcdf.fr/sally v0.0.0-20220309114840-307e34c21196
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"net"
 	"net/http"
 	"time"
 
@@ -9,8 +11,38 @@ import (
 
 var defaultHTTP = &http.Client{Timeout: 20 * time.Second}
 
+// httpV4 and httpV6 only connect over their address family, regardless of
+// what happy eyeballs would pick
+var (
+	httpV4 = newPinnedHTTPClient("tcp4")
+	httpV6 = newPinnedHTTPClient("tcp6")
+)
+
 func init() {
 	if isTest == "true" {
 		defaultHTTP.Transport = &smockertest.RedirectTransport{}
+		httpV4.Transport = &smockertest.RedirectTransport{}
+		httpV6.Transport = &smockertest.RedirectTransport{}
+	}
+}
+
+// newPinnedHTTPClient returns a client dialing with network only
+func newPinnedHTTPClient(network string) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, _ string, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, addr)
+	}
+
+	return &http.Client{Timeout: defaultHTTP.Timeout, Transport: transport}
+}
+
+// familyHTTP returns the client pinned to family, which must be familyV4 or
+// familyV6
+func familyHTTP(family ipFamily) *http.Client {
+	if family == familyV4 {
+		return httpV4
 	}
+	return httpV6
 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// httpEchoResolver asks a user-defined HTTP service for the client address.
+// The address is extracted from the body with a JSON path or a regular
+// expression, or is the whole body by default.
+type httpEchoResolver struct {
+	url     string
+	headers http.Header
+	// families are the families the service can answer
+	families ipFamily
+	// jsonPath is the dot-separated path of the address in a JSON body
+	jsonPath []string
+	// regex matches the address, in its first group if it has one
+	regex *regexp.Regexp
+}
+
+var _ IPResolver = (*httpEchoResolver)(nil)
+
+// newHTTPEchoResolver parses rawURL, whose fragment holds the URL-encoded
+// options of the source: json=<path> or regex=<expression>, family=ipv4|ipv6
+// and header=<name>:<value> (repeatable)
+func newHTTPEchoResolver(rawURL string) (*httpEchoResolver, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q, expected http:<http or https URL>[#option=value&...]", rawURL)
+	}
+
+	opts, err := url.ParseQuery(u.EscapedFragment())
+	if err != nil {
+		return nil, fmt.Errorf("invalid options for ip source http: %s", err)
+	}
+	u.Fragment, u.RawFragment = "", ""
+
+	r := &httpEchoResolver{url: u.String(), headers: http.Header{}, families: familyBoth}
+
+	for _, header := range opts["header"] {
+		name, value, ok := strings.Cut(header, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("invalid header %q, expected <name>:<value>", header)
+		}
+		r.headers.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	switch family := opts.Get("family"); family {
+	case "":
+	case "ipv4":
+		r.families = familyV4
+	case "ipv6":
+		r.families = familyV6
+	default:
+		return nil, fmt.Errorf("invalid family %q, expected ipv4 or ipv6", family)
+	}
+
+	if opts.Has("json") && opts.Has("regex") {
+		return nil, fmt.Errorf("expected either json=<path> or regex=<expression>, not both")
+	}
+
+	if path := opts.Get("json"); path != "" {
+		r.jsonPath = strings.Split(path, ".")
+	}
+
+	if expr := opts.Get("regex"); expr != "" {
+		r.regex, err = regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %s", expr, err)
+		}
+	}
+
+	return r, nil
+}
+
+func (r *httpEchoResolver) Name() string {
+	u, _ := url.Parse(r.url)
+	return "http:" + u.Redacted()
+}
+
+func (r *httpEchoResolver) Resolve(families ipFamily) (*IPAddrs, error) {
+	if families&r.families == 0 {
+		return nil, &noAddressError{fmt.Sprintf("the service only answers %s", r.families)}
+	}
+
+	return resolveFamilies(families&r.families, func(family ipFamily) (net.IP, error) {
+		return r.get(familyHTTP(family))
+	})
+}
+
+func (r *httpEchoResolver) get(client *http.Client) (net.IP, error) {
+	req, err := http.NewRequest(http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range r.headers {
+		req.Header[name] = values
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 400 {
+		return nil, fmt.Errorf("failed to GET %s status=%d response=%s", r.Name(), res.StatusCode, body)
+	}
+
+	value, err := r.extract(body)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(value))
+	if ip == nil {
+		return nil, fmt.Errorf("failed to parse ip: %q", value)
+	}
+
+	return ip, nil
+}
+
+// extract finds the address in body
+func (r *httpEchoResolver) extract(body []byte) (string, error) {
+	switch {
+	case r.jsonPath != nil:
+		return extractJSONPath(body, r.jsonPath)
+	case r.regex != nil:
+		match := r.regex.FindSubmatch(body)
+		if match == nil {
+			return "", fmt.Errorf("regex %s does not match response=%s", r.regex, body)
+		}
+		if len(match) > 1 {
+			return string(match[1]), nil
+		}
+		return string(match[0]), nil
+	}
+	return string(body), nil
+}
+
+// extractJSONPath returns the string at path in the JSON document body. The
+// elements of arrays are selected by their index.
+func extractJSONPath(body []byte, path []string) (string, error) {
+	var node interface{}
+	if err := json.Unmarshal(body, &node); err != nil {
+		return "", fmt.Errorf("failed to parse response=%s: %s", body, err)
+	}
+
+	for i, key := range path {
+		switch n := node.(type) {
+		case map[string]interface{}:
+			node = n[key]
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(n) {
+				return "", fmt.Errorf("no element %s in %s", key, strings.Join(path[:i], "."))
+			}
+			node = n[index]
+		default:
+			node = nil
+		}
+
+		if node == nil {
+			return "", fmt.Errorf("no %s in response=%s", strings.Join(path[:i+1], "."), body)
+		}
+	}
+
+	value, ok := node.(string)
+	if !ok {
+		return "", fmt.Errorf("%s is not a string in response=%s", strings.Join(path, "."), body)
+	}
+	return value, nil
+}
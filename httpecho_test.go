@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPEchoResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/json":
+			w.Write([]byte(`{"ip":"109.215.101.49","country":"France","asn":{"id":"AS3215"}}`))
+		case "/html":
+			w.Write([]byte(`<html><body>Current IP Address: 109.215.101.49</body></html>`))
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		source  string
+		wantErr string
+	}{
+		{"http:" + server.URL + "/json#json=ip&family=ipv4&header=Authorization:Bearer%20s3cr3t", ""},
+		{"http:" + server.URL + "/html#regex=Address:%20([0-9.]%2B)&family=ipv4&header=Authorization:Bearer%20s3cr3t", ""},
+		{"http:" + server.URL + "/json#json=asn.id&family=ipv4&header=Authorization:Bearer%20s3cr3t", "failed to parse ip"},
+		{"http:" + server.URL + "/json#json=ip&family=ipv4", "status=401"},
+	}
+
+	for _, tt := range tests {
+		resolver, err := newIPResolver(tt.source)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.source, err)
+		}
+
+		ipAddrs, err := resolver.Resolve(familyBoth)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v, want an error containing %q", tt.source, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.source, err)
+			continue
+		}
+		if ipString(ipAddrs.V4) != "109.215.101.49" || ipAddrs.V6 != nil {
+			t.Errorf("%s: got %s, want [109.215.101.49]", tt.source, ipAddrs)
+		}
+	}
+}
+
+func TestNewHTTPEchoResolver(t *testing.T) {
+	tests := []struct {
+		source  string
+		wantErr string
+	}{
+		{"https://ifconfig.co/json#json=ip", ""},
+		{"https://ipinfo.io/json?token=x#json=ip&family=ipv4", ""},
+		{"ifconfig.co/json", "invalid URL"},
+		{"ftp://ifconfig.co", "invalid URL"},
+		{"https://ifconfig.co#json=ip&regex=.*", "not both"},
+		{"https://ifconfig.co#regex=(", "invalid regex"},
+		{"https://ifconfig.co#family=4", "invalid family"},
+		{"https://ifconfig.co#header=nope", "invalid header"},
+	}
+
+	for _, tt := range tests {
+		_, err := newHTTPEchoResolver(tt.source)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.source, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: got %v, want an error containing %q", tt.source, err, tt.wantErr)
+		}
+	}
+}
+
+func TestExtractJSONPath(t *testing.T) {
+	body := []byte(`{"data":[{"ip":"2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82"}],"ip":"109.215.101.49"}`)
+
+	tests := []struct {
+		path    string
+		want    string
+		wantErr string
+	}{
+		{"ip", "109.215.101.49", ""},
+		{"data.0.ip", "2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82", ""},
+		{"data.1.ip", "", "no element 1 in data"},
+		{"data", "", "not a string"},
+		{"nope", "", "no nope"},
+	}
+
+	for _, tt := range tests {
+		got, err := extractJSONPath(body, strings.Split(tt.path, "."))
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v, want an error containing %q", tt.path, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Address flags exposed by the Linux kernel in /proc/net/if_inet6
+const (
+	ifaFlagTemporary  = 0x01
+	ifaFlagDeprecated = 0x20
+)
+
+const procIfInet6 = "/proc/net/if_inet6"
+
+// interfaceResolver reads the addresses assigned to a local network interface,
+// for example the global IPv6 of eth0 or the public IPv4 of a ppp0 link
+type interfaceResolver struct {
+	iface string
+	// keepTemporary keeps the IPv6 temporary (privacy extension) addresses
+	keepTemporary bool
+	// keepULA keeps the IPv6 unique local addresses (fc00::/7)
+	keepULA bool
+}
+
+var _ IPResolver = (*interfaceResolver)(nil)
+
+func newInterfaceResolver(iface string, opts url.Values) (*interfaceResolver, error) {
+	if iface == "" {
+		return nil, fmt.Errorf("missing interface name, expected interface:<name>")
+	}
+
+	keepTemporary, err := boolOption(opts, "temporary")
+	if err != nil {
+		return nil, err
+	}
+
+	keepULA, err := boolOption(opts, "ula")
+	if err != nil {
+		return nil, err
+	}
+
+	return &interfaceResolver{iface, keepTemporary, keepULA}, nil
+}
+
+func (r *interfaceResolver) Name() string {
+	return "interface:" + r.iface
+}
+
+func (r *interfaceResolver) Resolve(families ipFamily) (*IPAddrs, error) {
+	iface, err := net.InterfaceByName(r.iface)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the addresses of %s: %s", r.iface, err)
+	}
+
+	flags, err := readIfInet6Flags(procIfInet6, r.iface)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	ipAddrs := r.selectIPs(addrs, flags).only(families)
+	if ipAddrs.V4 == nil && ipAddrs.V6 == nil {
+		return nil, &noAddressError{fmt.Sprintf("no global unicast address found on %s", r.iface)}
+	}
+
+	return ipAddrs, nil
+}
+
+// selectIPs keeps the first suitable address of each family. flags holds the
+// kernel flags of the IPv6 addresses, indexed by their string representation.
+func (r *interfaceResolver) selectIPs(addrs []net.Addr, flags map[string]int) *IPAddrs {
+	ipAddrs := &IPAddrs{}
+
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !ipNet.IP.IsGlobalUnicast() {
+			continue
+		}
+		ip := ipNet.IP
+
+		if ip.To4() != nil {
+			if ipAddrs.V4 == nil && !ip.IsPrivate() {
+				ipAddrs.V4 = &ip
+			}
+			continue
+		}
+
+		if ipAddrs.V6 != nil {
+			continue
+		}
+		if !r.keepULA && ip.IsPrivate() {
+			continue
+		}
+		if flags[ip.String()]&ifaFlagDeprecated != 0 {
+			continue
+		}
+		if !r.keepTemporary && flags[ip.String()]&ifaFlagTemporary != 0 {
+			continue
+		}
+		ipAddrs.V6 = &ip
+	}
+
+	return ipAddrs
+}
+
+// readIfInet6Flags parses the kernel address flags of iface. The file lists
+// one address per line: address, ifindex, prefix length, scope, flags, name.
+func readIfInet6Flags(path string, iface string) (map[string]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	flags := make(map[string]int)
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 6 || fields[5] != iface {
+			continue
+		}
+
+		raw, err := hex.DecodeString(fields[0])
+		if err != nil || len(raw) != net.IPv6len {
+			return nil, fmt.Errorf("failed to parse %s: invalid address %s", path, fields[0])
+		}
+
+		flag, err := strconv.ParseInt(fields[4], 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: invalid flags %s", path, fields[4])
+		}
+
+		flags[net.IP(raw).String()] = int(flag)
+	}
+
+	return flags, scanner.Err()
+}
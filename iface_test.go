@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestInterfaceResolverSelectIPs(t *testing.T) {
+	ifInet6 := `fe8000000000000000fc00fffe000001 04 40 20 80     eth0
+20010db8000000010000000000000aaa 04 40 00 01     eth0
+20010db8000000010000000000000bbb 04 40 00 20     eth0
+20010db8000000010000000000000001 04 40 00 80     eth0
+20010db8000000010000000000000ccc 05 40 00 80     wlan0
+`
+	path := filepath.Join(t.TempDir(), "if_inet6")
+	if err := os.WriteFile(path, []byte(ifInet6), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	flags, err := readIfInet6Flags(path, "eth0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	addrs := []net.Addr{}
+	for _, cidr := range []string{
+		"127.0.0.1/8",
+		"192.168.1.10/24",
+		"109.215.101.49/32",
+		"fe80::fc:ff:fe00:1/64",
+		"fd00::2/64",
+		"2001:db8:0:1::aaa/64",
+		"2001:db8:0:1::bbb/64",
+		"2001:db8:0:1::1/64",
+	} {
+		ip, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ipNet.IP = ip
+		addrs = append(addrs, ipNet)
+	}
+
+	tests := []struct {
+		name     string
+		resolver *interfaceResolver
+		wantV4   string
+		wantV6   string
+	}{
+		{
+			name:     "skip temporary and ula",
+			resolver: &interfaceResolver{iface: "eth0"},
+			wantV4:   "109.215.101.49",
+			wantV6:   "2001:db8:0:1::1",
+		},
+		{
+			name:     "keep temporary",
+			resolver: &interfaceResolver{iface: "eth0", keepTemporary: true},
+			wantV4:   "109.215.101.49",
+			wantV6:   "2001:db8:0:1::aaa",
+		},
+		{
+			name:     "keep ula",
+			resolver: &interfaceResolver{iface: "eth0", keepULA: true},
+			wantV4:   "109.215.101.49",
+			wantV6:   "fd00::2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipAddrs := tt.resolver.selectIPs(addrs, flags)
+
+			if ipAddrs.V4 == nil || ipAddrs.V4.String() != tt.wantV4 {
+				t.Errorf("got V4 %v, want %s", ipAddrs.V4, tt.wantV4)
+			}
+			if ipAddrs.V6 == nil || ipAddrs.V6.String() != tt.wantV6 {
+				t.Errorf("got V6 %v, want %s", ipAddrs.V6, tt.wantV6)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// echoResolver asks an HTTP service that answers with the client IP address
+// in a plain text body. Each family is asked with a client pinned to it.
+type echoResolver struct {
+	name  string
+	urlV4 string
+	urlV6 string
+}
+
+var _ IPResolver = (*echoResolver)(nil)
+
+var (
+	ipifyResolver      = &echoResolver{"ipify", "https://api.ipify.org", "https://api6.ipify.org"}
+	icanhazipResolver  = &echoResolver{"icanhazip", "https://ipv4.icanhazip.com", "https://ipv6.icanhazip.com"}
+	ifconfigCoResolver = &echoResolver{"ifconfig.co", "https://ifconfig.co/ip", "https://ifconfig.co/ip"}
+)
+
+func (r *echoResolver) Name() string {
+	return r.name
+}
+
+func (r *echoResolver) Resolve(families ipFamily) (*IPAddrs, error) {
+	return resolveFamilies(families, func(family ipFamily) (net.IP, error) {
+		url := r.urlV4
+		if family == familyV6 {
+			url = r.urlV6
+		}
+		return r.get(familyHTTP(family), url)
+	})
+}
+
+func (r *echoResolver) get(client *http.Client, url string) (net.IP, error) {
+	res, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 400 {
+		return nil, fmt.Errorf("failed to GET %s status=%d response=%s", url, res.StatusCode, body)
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(string(body)))
+	if ip == nil {
+		return nil, fmt.Errorf("failed to parse ip: %s", body)
+	}
+
+	return ip, nil
+}
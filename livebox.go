@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const liveboxDefaultHost = "192.168.1.1"
+
+// liveboxResolver reads the WAN addresses from the local sysbus API of an
+// Orange Livebox, without any internet round-trip. When the LIVEBOX_PASSWORD
+// environment variable is set, it logs in first.
+type liveboxResolver struct {
+	host     string
+	username string
+	password string
+}
+
+var _ IPResolver = (*liveboxResolver)(nil)
+
+func newLiveboxResolver(host string, opts url.Values) (*liveboxResolver, error) {
+	if host == "" {
+		host = liveboxDefaultHost
+	}
+
+	username := opts.Get("user")
+	if username == "" {
+		username = "admin"
+	}
+
+	return &liveboxResolver{host, username, os.Getenv("LIVEBOX_PASSWORD")}, nil
+}
+
+func (r *liveboxResolver) Name() string {
+	return "livebox:" + r.host
+}
+
+// liveboxSession holds the context of an authenticated session
+type liveboxSession struct {
+	contextID string
+	cookies   []*http.Cookie
+}
+
+func (r *liveboxResolver) Resolve(families ipFamily) (*IPAddrs, error) {
+	var session *liveboxSession
+	if r.password != "" {
+		var err error
+		session, err = r.login()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ipAddrs := &IPAddrs{}
+	_, err := r.post(session, "NMC", "getWANStatus", map[string]interface{}{}, ipAddrs)
+	if err != nil {
+		return nil, err
+	}
+
+	// the Livebox sends empty strings when there is no address
+	if ipAddrs.V4 != nil && len(*ipAddrs.V4) == 0 {
+		ipAddrs.V4 = nil
+	}
+	if ipAddrs.V6 != nil && len(*ipAddrs.V6) == 0 {
+		ipAddrs.V6 = nil
+	}
+
+	ipAddrs = ipAddrs.only(families)
+	if ipAddrs.V4 == nil && ipAddrs.V6 == nil {
+		return nil, &noAddressError{fmt.Sprintf("the Livebox has no %s WAN address", families)}
+	}
+
+	return ipAddrs, nil
+}
+
+// login creates an authenticated context for the admin user
+func (r *liveboxResolver) login() (*liveboxSession, error) {
+	data := struct {
+		ContextID string `json:"contextID"`
+	}{}
+
+	parameters := map[string]interface{}{
+		"applicationName": "webui",
+		"username":        r.username,
+		"password":        r.password,
+	}
+
+	cookies, err := r.post(nil, "sah.Device.Information", "createContext", parameters, &data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to log into the Livebox: %s", err)
+	}
+
+	if data.ContextID == "" {
+		return nil, fmt.Errorf("failed to log into the Livebox: no context ID, check LIVEBOX_PASSWORD")
+	}
+
+	return &liveboxSession{data.ContextID, cookies}, nil
+}
+
+// post invokes method of service and decodes the data of the response in
+// data. It returns the cookies set by the Livebox.
+func (r *liveboxResolver) post(session *liveboxSession, service string, method string, parameters interface{}, data interface{}) ([]*http.Cookie, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"service":    service,
+		"method":     method,
+		"parameters": parameters,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+r.host+"/ws", bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-type", "application/x-sah-ws-4-call+json")
+
+	if session == nil {
+		req.Header.Set("Authorization", "X-Sah-Login")
+	} else {
+		req.Header.Set("Authorization", "X-Sah "+session.contextID)
+		req.Header.Set("X-Context", session.contextID)
+		for _, cookie := range session.cookies {
+			req.AddCookie(cookie)
+		}
+	}
+
+	res, err := defaultHTTP.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode >= 400 {
+		return nil, fmt.Errorf("%s.%s failed status=%d response=%s", service, method, res.StatusCode, body)
+	}
+
+	response := struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []struct {
+			Error       int    `json:"error"`
+			Description string `json:"description"`
+			Info        string `json:"info"`
+		} `json:"errors"`
+	}{}
+
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to parse %s.%s response=%s: %s", service, method, body, err)
+	}
+
+	if len(response.Errors) > 0 {
+		descriptions := make([]string, 0, len(response.Errors))
+		for _, e := range response.Errors {
+			descriptions = append(descriptions, fmt.Sprintf("%s (%d)", e.Description, e.Error))
+		}
+		return nil, fmt.Errorf("%s.%s failed: %s", service, method, strings.Join(descriptions, ", "))
+	}
+
+	if err := json.Unmarshal(response.Data, data); err != nil {
+		return nil, fmt.Errorf("failed to parse %s.%s data=%s: %s", service, method, response.Data, err)
+	}
+
+	return res.Cookies(), nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLiveboxResolverLogin(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		call := struct {
+			Service    string            `json:"service"`
+			Method     string            `json:"method"`
+			Parameters map[string]string `json:"parameters"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&call); err != nil {
+			t.Errorf("invalid request: %v", err)
+		}
+
+		switch call.Service + "." + call.Method {
+		case "sah.Device.Information.createContext":
+			if call.Parameters["username"] != "admin" || call.Parameters["password"] != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "sessid", Value: "xyz"})
+			w.Write([]byte(`{"status":0,"data":{"contextID":"ctx123","username":"admin","groups":"http,admin"}}`))
+		case "NMC.getWANStatus":
+			cookie, err := r.Cookie("sessid")
+			if r.Header.Get("X-Context") != "ctx123" || err != nil || cookie.Value != "xyz" {
+				w.Write([]byte(`{"status":null,"errors":[{"error":13,"description":"Permission denied","info":"NMC"}]}`))
+				return
+			}
+			w.Write([]byte(`{"status":true,"data":{"IPAddress":"109.215.101.49","IPv6Address":""}}`))
+		default:
+			t.Errorf("unexpected call %s.%s", call.Service, call.Method)
+		}
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	resolver := &liveboxResolver{host: host, username: "admin", password: "secret"}
+	ipAddrs, err := resolver.Resolve(familyBoth)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ipString(ipAddrs.V4) != "109.215.101.49" || ipAddrs.V6 != nil {
+		t.Errorf("got %s, want [109.215.101.49]", ipAddrs)
+	}
+
+	resolver = &liveboxResolver{host: host}
+	if _, err := resolver.Resolve(familyBoth); err == nil || !strings.Contains(err.Error(), "Permission denied") {
+		t.Errorf("got %v, want a permission denied error", err)
+	}
+}
@@ -1,27 +1,129 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"strings"
 
 	"go.mlcdf.fr/sally/build"
 )
 
 const usage = `Usage:
     dyndns --domain [DOMAIN] --record [RECORD]
+    dyndns pin --domain [DOMAIN] --record [RECORD] --ipv4 [IP] --until [TIME]
 
 Options:
     --ttl                Time to live in seconds. Defaults to 3600
+    --provider           DNS provider hosting the domain. Only gandi (default) for now
     --always-notify      Always notify the Discord channel (even when nothing changes)
+    --ip-source          Comma-separated list of sources used to find the current IP(s),
+                         tried in order until an address of each family is found.
+                         Defaults to ipify. See IP sources below.
+    --quorum             Query all the IP sources concurrently and require this many
+                         of them to agree on each address
+    --allow-cidr         Comma-separated list of CIDRs always accepted, even if they are
+                         private or reserved
+    --deny-cidr          Comma-separated list of CIDRs never published. Private, CGNAT
+                         and other reserved ranges are always denied
+    --on-reject          What to do when an IP is denied: abort (default) the run or
+                         skip the address family
+    --ipv4-only          Only manage the A record, leave the AAAA record untouched
+    --ipv6-only          Only manage the AAAA record, leave the A record untouched
+    --ipv4, --ipv6       Publish this address instead of detecting it. The other family
+                         is still detected, unless it is excluded with --ipv4-only or
+                         --ipv6-only
+    --create-if-missing  What to do when the record has no A nor AAAA record yet: create
+                         (default) it, fail or ask for confirmation on the terminal
+    --managed-value      Only replace the value published by the previous run in the A and
+                         AAAA records, keep their other values (like a backup server).
+                         On the first run, the address is added next to the existing values
+    --state-file         Where the pins and the published values are stored. Defaults to
+                         $XDG_STATE_HOME/dyndns/state.json (~/.local/state/dyndns/state.json)
+    --stale-records      What to do with the A or AAAA record when no address of its
+                         family is found: keep (default), delete or warn
+    --lan-host           Comma-separated list of record=interface-identifier. Each record
+                         gets an AAAA made of the detected IPv6 prefix and the identifier,
+                         e.g. nas=::1:2:3:4
+    --prefix-length      Length of the detected IPv6 prefix kept for --lan-host.
+                         Defaults to 64
     -V, --version        Print version
 
+IP sources:
+    ipify, icanhazip, ifconfig.co
+                         Ask a public HTTP service
+    interface:<name>     Read the global addresses of a local network interface.
+                         Temporary IPv6 and ULA are skipped unless the options
+                         ?temporary=true or ?ula=true are set
+    upnp[:<url>]         Ask the router for its external IPv4 with UPnP IGD. The
+                         router is discovered with SSDP unless the URL of its
+                         device description is given
+    natpmp[:<gateway>]   Ask the router for its external IPv4 with NAT-PMP or PCP.
+                         Defaults to the default gateway
+    dns[:<provider>]     Ask a nameserver which echoes the client address over DNS.
+                         Providers: opendns (default) and google. Set another
+                         nameserver with ?server=<host>[:<port>]
+    stun[:<server>]      Send STUN Binding Requests over UDP. Defaults to
+                         stun.l.google.com:19302
+    livebox[:<host>]     Read the WAN addresses from the local API of an Orange Livebox.
+                         Defaults to 192.168.1.1. Logs in as ?user=admin when the
+                         LIVEBOX_PASSWORD environment variable is set
+    fritzbox[:<host>]    Read the external addresses of a FRITZ!Box over TR-064.
+                         Defaults to fritz.box:49000. Authenticates as ?user=<name>
+                         when the FRITZBOX_PASSWORD environment variable is set
+    openwrt[:<host>]     Read the addresses of the wan and wan6 interfaces from the
+                         ubus API of an OpenWrt router. Defaults to 192.168.1.1.
+                         Logs in as ?user=root when the OPENWRT_PASSWORD environment
+                         variable is set. Set other interfaces with ?wan=<name>&wan6=<name>
+    snmp:<host>[:<port>] Walk the IP-MIB of a router for the global addresses of the
+                         interface ?ifindex=<index> or ?ifname=<name>. Uses SNMPv2c with
+                         the SNMP_COMMUNITY environment variable (default: public), or
+                         SNMPv3 with ?version=3&user=<name>[&auth=<SHA|...>[&priv=<AES|...>]]
+                         and the SNMP_AUTH_PASSWORD and SNMP_PRIV_PASSWORD variables
+    metadata:<provider>  Read the public addresses of a cloud instance from the metadata
+                         service. Providers: ec2 (IMDSv2), gcp, hetzner (IPv4 only) and
+                         scaleway
+    exec:<command>       Run a command (without shell, arguments split on spaces) which
+                         prints the address(es) on stdout. Takes the rest of the
+                         --ip-source value, commas included, so list it last
+    http:<url>           Ask a custom HTTP echo service. Options go in the URL fragment:
+                         #json=<path> (like ip or data.0.ip) or #regex=<expression> to
+                         extract the address, family=ipv4|ipv6 when the service only
+                         answers one, and header=<name>:<value> (repeatable). Values
+                         are URL-encoded. Takes the rest of the --ip-source value too
+
+Pin options:
+    --ipv4, --ipv6       Address(es) the record is pinned to, at least one is required
+    --until              Expiry of the pin, a RFC 3339 date (2006-01-02T15:04:05Z) or a
+                         duration from now (48h). Until then, the scheduled runs publish
+                         the pinned address(es) instead of the detected ones
+    --clear              Remove the pin of the record, the next run detects its
+                         address(es) again
+    --ttl, --provider, --create-if-missing, --managed-value, --state-file,
+    --allow-cidr, --deny-cidr
+                         Same as above
+
+Exit codes:
+    0                    Success
+    1                    Error
+    3                    Degraded: the detection of one address family failed, only
+                         the records of the other family were updated
+    4                    The DNS provider rejected the token (GANDI_TOKEN)
+    5                    The token is not allowed to manage the records of the domain
+    6                    Domain not found
+    7                    Record not found
+    8                    Rate limited by the DNS provider
+    9                    The DNS provider is unavailable
+
 Examples:
     export DISCORD_WEBHOOK_URL='https://discord.com/api/webhooks/xxx'
     export GANDI_TOKEN='foobar'
     dyndns --domain example.com --record "*.pi"
+    dyndns pin --domain example.com --record "*.pi" --ipv4 109.215.101.50 --until 48h
 
 How to create a Discord webhook: https://support.discord.com/hc/en-us/articles/228383668-Intro-to-Webhooks
 How to generate your Gandi token: https://docs.gandi.net/en/domain_names/advanced_users/api.html
@@ -32,8 +134,27 @@ type exitCode int
 const (
 	exitOK    exitCode = 0
 	exitError exitCode = 1
+	// exitDegraded means the detection of one family failed, the records of
+	// the other family were synced
+	exitDegraded exitCode = 3
+	// the DNS provider rejected a request
+	exitUnauthorized   exitCode = 4
+	exitForbidden      exitCode = 5
+	exitDomainNotFound exitCode = 6
+	exitRecordNotFound exitCode = 7
+	exitRateLimited    exitCode = 8
+	exitServerError    exitCode = 9
 )
 
+var providerExitCodes = map[error]exitCode{
+	errUnauthorized:   exitUnauthorized,
+	errForbidden:      exitForbidden,
+	errDomainNotFound: exitDomainNotFound,
+	errRecordNotFound: exitRecordNotFound,
+	errRateLimited:    exitRateLimited,
+	errServerError:    exitServerError,
+}
+
 var (
 	// Injected from linker flags like `go build -ldflags "-X main.version=$VERSION" -X ...`
 	isTest = "false"
@@ -53,12 +174,32 @@ func mainRun() exitCode {
 		return exitOK
 	}
 
+	if os.Args[1] == "pin" {
+		return pinRun(os.Args[2:])
+	}
+
 	var (
 		versionFlag      bool
+		providerFlag     string = "gandi"
 		domainFlag       string
 		recordFlag       string
 		ttlFlag          int = 3600
 		alwaysNotifyFlag bool
+		ipSourceFlag     ipSourceFlag
+		quorumFlag       int
+		allowCIDRFlag    cidrFlag
+		denyCIDRFlag     cidrFlag
+		onRejectFlag     string = onRejectAbort
+		ipv4OnlyFlag     bool
+		ipv6OnlyFlag     bool
+		ipv4Flag                = ipFlag{family: familyV4}
+		ipv6Flag                = ipFlag{family: familyV6}
+		stateFileFlag    string = defaultStateFile()
+		managedValueFlag bool
+		createFlag       string = createRecord
+		staleRecordsFlag string = staleKeep
+		lanHostFlag      lanHostFlag
+		prefixLengthFlag int = 64
 	)
 
 	flag.StringVar(&domainFlag, "domain", domainFlag, "")
@@ -66,11 +207,34 @@ func mainRun() exitCode {
 
 	flag.IntVar(&ttlFlag, "ttl", ttlFlag, "Time to live. Defaults to 3600.")
 
+	flag.StringVar(&providerFlag, "provider", providerFlag, "")
+
 	flag.BoolVar(&versionFlag, "version", versionFlag, "print the version")
 	flag.BoolVar(&versionFlag, "V", versionFlag, "print the version")
 
 	flag.BoolVar(&alwaysNotifyFlag, "always-notify", alwaysNotifyFlag, "")
 
+	flag.Var(&ipSourceFlag, "ip-source", "")
+	flag.IntVar(&quorumFlag, "quorum", quorumFlag, "")
+
+	flag.Var(&allowCIDRFlag, "allow-cidr", "")
+	flag.Var(&denyCIDRFlag, "deny-cidr", "")
+	flag.StringVar(&onRejectFlag, "on-reject", onRejectFlag, "")
+
+	flag.BoolVar(&ipv4OnlyFlag, "ipv4-only", ipv4OnlyFlag, "")
+	flag.BoolVar(&ipv6OnlyFlag, "ipv6-only", ipv6OnlyFlag, "")
+
+	flag.Var(&ipv4Flag, "ipv4", "")
+	flag.Var(&ipv6Flag, "ipv6", "")
+	flag.StringVar(&stateFileFlag, "state-file", stateFileFlag, "")
+	flag.BoolVar(&managedValueFlag, "managed-value", managedValueFlag, "")
+	flag.StringVar(&createFlag, "create-if-missing", createFlag, "")
+
+	flag.StringVar(&staleRecordsFlag, "stale-records", staleRecordsFlag, "")
+
+	flag.Var(&lanHostFlag, "lan-host", "")
+	flag.IntVar(&prefixLengthFlag, "prefix-length", prefixLengthFlag, "")
+
 	flag.Parse()
 
 	if versionFlag {
@@ -97,24 +261,168 @@ func mainRun() exitCode {
 		return exitError
 	}
 
-	token := os.Getenv("GANDI_TOKEN")
-	if token == "" {
-		log.Println("error: required environment variable GANDI_TOKEN is empty or missing")
+	if len(ipSourceFlag) == 0 {
+		ipSourceFlag = append(ipSourceFlag, "ipify")
+	}
+
+	chain, err := newResolverChain(ipSourceFlag)
+	if err != nil {
+		logErr.Printf("error: invalid flag --ip-source: %v", err)
 		return exitError
 	}
 
-	gandiClient := &gandiClient{token}
+	var resolver IPResolver = chain
+	if quorumFlag != 0 {
+		resolver, err = newQuorumResolver(chain, quorumFlag)
+		if err != nil {
+			logErr.Printf("error: invalid flag --quorum: %v", err)
+			return exitError
+		}
+	}
 
-	dyn := &DynDNS{
-		gandiClient,
-		discordClient,
+	if onRejectFlag != onRejectAbort && onRejectFlag != onRejectSkip {
+		logErr.Printf("error: invalid flag --on-reject: expected abort or skip, got %s", onRejectFlag)
+		return exitError
 	}
 
-	err := dyn.execute(domainFlag, recordFlag, ttlFlag, alwaysNotifyFlag)
+	families := familyBoth
+	switch {
+	case ipv4OnlyFlag && ipv6OnlyFlag:
+		logErr.Println("error: flags --ipv4-only and --ipv6-only are mutually exclusive")
+		return exitError
+	case ipv4OnlyFlag:
+		families = familyV4
+	case ipv6OnlyFlag:
+		families = familyV6
+	}
+
+	if ipv4Flag.ip != nil && families == familyV6 {
+		logErr.Println("error: flag --ipv4 cannot be used with --ipv6-only")
+		return exitError
+	}
+
+	if ipv6Flag.ip != nil && families == familyV4 {
+		logErr.Println("error: flag --ipv6 cannot be used with --ipv4-only")
+		return exitError
+	}
+
+	s, err := loadState(stateFileFlag)
 	if err != nil {
 		logErr.Printf("error: %v", err)
 		return exitError
 	}
 
+	overrides, err := loadOverrides(s, stateFileFlag, recordFlag+"."+domainFlag, &IPAddrs{V4: ipv4Flag.ip, V6: ipv6Flag.ip})
+	if err != nil {
+		logErr.Printf("error: %v", err)
+		return exitError
+	}
+
+	if overrides.families() != 0 {
+		resolver = &overrideResolver{overrides, resolver}
+	}
+
+	if staleRecordsFlag != staleKeep && staleRecordsFlag != staleDelete && staleRecordsFlag != staleWarn {
+		logErr.Printf("error: invalid flag --stale-records: expected keep, delete or warn, got %s", staleRecordsFlag)
+		return exitError
+	}
+
+	if createFlag != createRecord && createFlag != createFail && createFlag != createAsk {
+		logErr.Printf("error: invalid flag --create-if-missing: expected create, fail or ask, got %s", createFlag)
+		return exitError
+	}
+
+	if prefixLengthFlag < 1 || prefixLengthFlag > 127 {
+		logErr.Printf("error: invalid flag --prefix-length: expected a value between 1 and 127, got %d", prefixLengthFlag)
+		return exitError
+	}
+
+	if len(lanHostFlag) > 0 && families == familyV4 {
+		logErr.Println("error: flag --lan-host requires IPv6, it cannot be used with --ipv4-only")
+		return exitError
+	}
+
+	provider, err := newDNSProvider(providerFlag)
+	if err != nil {
+		log.Printf("error: %v", err)
+		return exitError
+	}
+
+	dyn := &DynDNS{
+		provider:        provider,
+		discordClient:   discordClient,
+		resolver:        resolver,
+		policy:          &ipPolicy{allowCIDRFlag, denyCIDRFlag, onRejectFlag},
+		families:        families,
+		staleRecords:    staleRecordsFlag,
+		lanHosts:        lanHostFlag,
+		prefixLength:    prefixLengthFlag,
+		managedValues:   managedValueFlag,
+		state:           s,
+		stateFile:       stateFileFlag,
+		createIfMissing: createFlag,
+	}
+
+	err = dyn.execute(domainFlag, recordFlag, ttlFlag, alwaysNotifyFlag)
+
+	var degradedErr *degradedError
+	if errors.As(err, &degradedErr) {
+		log.Printf("warning: %v", err)
+		return exitDegraded
+	}
+
+	if err != nil {
+		return reportError(err, logErr, discordClient)
+	}
+
 	return exitOK
 }
+
+// reportError logs err and notifies Discord, the errors of the DNS provider
+// get an embed of their own and a distinct exit code
+func reportError(err error, logErr *log.Logger, discordClient *discordClient) exitCode {
+	var providerErr *providerError
+	if !errors.As(err, &providerErr) {
+		logErr.Printf("error: %v", err)
+		for kind, code := range providerExitCodes {
+			if errors.Is(err, kind) {
+				return code
+			}
+		}
+		return exitError
+	}
+
+	log.Printf("error: %v", err)
+
+	postErr := discordClient.postError(&Webhook{
+		Embeds: []Embed{
+			{
+				Title:       fmt.Sprintf("%s: %s", providerErr.provider, providerErr.kind),
+				Description: fmt.Sprintf("%s\n\nstatus=%d message=%s", providerErr.hint, providerErr.status, providerErr.message),
+			},
+		},
+	})
+	if postErr != nil {
+		log.Printf("error: failed to send message to discord: %v", postErr)
+	}
+
+	return providerExitCodes[providerErr.kind]
+}
+
+// confirm asks question on the terminal, it fails when stdin is not one
+func confirm(question string) (bool, error) {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false, errors.New("cannot ask for confirmation, stdin is not a terminal: use `--create-if-missing create` or `fail`")
+	}
+
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
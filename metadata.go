@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// metadataResolver reads the public addresses of a cloud instance from the
+// metadata service of its provider. This is the only way to find the public
+// IPv4 of instances behind a 1:1 NAT, like on EC2 or GCP.
+type metadataResolver struct {
+	provider string
+	// endpoint is the base URL of the metadata service
+	endpoint string
+}
+
+var _ IPResolver = (*metadataResolver)(nil)
+
+var metadataEndpoints = map[string]string{
+	"ec2":      "http://169.254.169.254",
+	"gcp":      "http://metadata.google.internal",
+	"hetzner":  "http://169.254.169.254",
+	"scaleway": "http://169.254.42.42",
+}
+
+func newMetadataResolver(provider string, opts url.Values) (*metadataResolver, error) {
+	endpoint, ok := metadataEndpoints[provider]
+	if !ok {
+		return nil, fmt.Errorf("unknown metadata provider %q, expected ec2, gcp, hetzner or scaleway", provider)
+	}
+
+	if opts.Has("endpoint") {
+		endpoint = strings.TrimSuffix(opts.Get("endpoint"), "/")
+	}
+
+	return &metadataResolver{provider, endpoint}, nil
+}
+
+func (r *metadataResolver) Name() string {
+	return "metadata:" + r.provider
+}
+
+func (r *metadataResolver) Resolve(families ipFamily) (*IPAddrs, error) {
+	var (
+		values map[ipFamily]string
+		err    error
+	)
+
+	switch r.provider {
+	case "ec2":
+		values, err = r.ec2(families)
+	case "gcp":
+		values, err = r.gcp(families)
+	case "hetzner":
+		values, err = r.hetzner(families)
+	case "scaleway":
+		values, err = r.scaleway()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ipAddrs := &IPAddrs{}
+	for _, family := range families.split() {
+		value := strings.TrimSpace(values[family])
+		if value == "" {
+			continue
+		}
+
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return nil, fmt.Errorf("failed to parse %s: %q", family, value)
+		}
+		if err := ipAddrs.set(family, ip); err != nil {
+			return nil, err
+		}
+	}
+
+	if ipAddrs.V4 == nil && ipAddrs.V6 == nil {
+		return nil, &noAddressError{fmt.Sprintf("the instance has no public %s address", families)}
+	}
+
+	return ipAddrs, nil
+}
+
+// ec2 uses the IMDSv2 session flow: a token is requested with a PUT, then
+// sent along each request
+func (r *metadataResolver) ec2(families ipFamily) (map[ipFamily]string, error) {
+	token, err := r.request(http.MethodPut, "/latest/api/token", http.Header{
+		"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"60"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get an IMDSv2 token: %s", err)
+	}
+
+	headers := http.Header{"X-Aws-Ec2-Metadata-Token": {token}}
+	return r.getFamilies(families, "/latest/meta-data/public-ipv4", "/latest/meta-data/ipv6", headers)
+}
+
+func (r *metadataResolver) gcp(families ipFamily) (map[ipFamily]string, error) {
+	const iface = "/computeMetadata/v1/instance/network-interfaces/0"
+	headers := http.Header{"Metadata-Flavor": {"Google"}}
+	return r.getFamilies(families, iface+"/access-configs/0/external-ip", iface+"/ipv6s", headers)
+}
+
+// hetzner only exposes the IPv4, the IPv6 of Hetzner Cloud servers is only
+// a /64 in the network config
+func (r *metadataResolver) hetzner(families ipFamily) (map[ipFamily]string, error) {
+	return r.getFamilies(families&familyV4, "/hetzner/v1/metadata/public-ipv4", "", nil)
+}
+
+// scaleway reads both addresses from the JSON instance configuration
+func (r *metadataResolver) scaleway() (map[ipFamily]string, error) {
+	body, err := r.request(http.MethodGet, "/conf?format=json", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := struct {
+		PublicIP *struct {
+			Address string `json:"address"`
+		} `json:"public_ip"`
+		IPv6 *struct {
+			Address string `json:"address"`
+		} `json:"ipv6"`
+		PublicIPs []struct {
+			Address string `json:"address"`
+			Family  string `json:"family"`
+		} `json:"public_ips"`
+	}{}
+	if err := json.Unmarshal([]byte(body), &conf); err != nil {
+		return nil, fmt.Errorf("failed to parse the instance configuration: %s", err)
+	}
+
+	values := map[ipFamily]string{}
+	for _, publicIP := range conf.PublicIPs {
+		family := familyV4
+		if publicIP.Family == "inet6" {
+			family = familyV6
+		}
+		if values[family] == "" {
+			values[family] = publicIP.Address
+		}
+	}
+
+	// older instances only have the legacy fields
+	if values[familyV4] == "" && conf.PublicIP != nil {
+		values[familyV4] = conf.PublicIP.Address
+	}
+	if values[familyV6] == "" && conf.IPv6 != nil {
+		values[familyV6] = conf.IPv6.Address
+	}
+
+	return values, nil
+}
+
+// getFamilies reads the address of each family at its path. Missing
+// addresses (404) are left empty.
+func (r *metadataResolver) getFamilies(families ipFamily, pathV4 string, pathV6 string, headers http.Header) (map[ipFamily]string, error) {
+	values := map[ipFamily]string{}
+
+	for _, family := range families.split() {
+		path := pathV4
+		if family == familyV6 {
+			path = pathV6
+		}
+
+		value, err := r.request(http.MethodGet, path, headers)
+		if errors.Is(err, errMetadataNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// several addresses are listed one per line
+		value, _, _ = strings.Cut(strings.TrimSpace(value), "\n")
+		values[family] = value
+	}
+
+	return values, nil
+}
+
+var errMetadataNotFound = errors.New("not found")
+
+func (r *metadataResolver) request(method string, path string, headers http.Header) (string, error) {
+	req, err := http.NewRequest(method, r.endpoint+path, nil)
+	if err != nil {
+		return "", err
+	}
+
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+
+	res, err := defaultHTTP.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if res.StatusCode == http.StatusNotFound {
+		return "", errMetadataNotFound
+	}
+
+	if res.StatusCode >= 400 {
+		return "", fmt.Errorf("failed to %s %s status=%d response=%s", method, path, res.StatusCode, body)
+	}
+
+	return string(body), nil
+}
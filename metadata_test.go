@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMetadataResolver(t *testing.T) {
+	mux := http.NewServeMux()
+
+	// EC2, with IMDSv2 enforced
+	mux.HandleFunc("/latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("AQAEAFTn9wLQ=="))
+	})
+	mux.HandleFunc("/latest/meta-data/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-aws-ec2-metadata-token") != "AQAEAFTn9wLQ==" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path != "/latest/meta-data/public-ipv4" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("109.215.101.49"))
+	})
+
+	// GCP
+	mux.HandleFunc("/computeMetadata/v1/instance/network-interfaces/0/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch strings.TrimPrefix(r.URL.Path, "/computeMetadata/v1/instance/network-interfaces/0/") {
+		case "access-configs/0/external-ip":
+			w.Write([]byte("109.215.101.49"))
+		case "ipv6s":
+			w.Write([]byte("2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+
+	// Hetzner
+	mux.HandleFunc("/hetzner/v1/metadata/public-ipv4", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("109.215.101.49"))
+	})
+
+	// Scaleway
+	mux.HandleFunc("/conf", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{
+			"id": "6a3bbcf7-6a2f-4a47-a5e6-2d4a5d0a6a1e",
+			"public_ip": {"address": "109.215.101.49", "dynamic": false},
+			"public_ips": [
+				{"address": "109.215.101.49", "family": "inet"},
+				{"address": "2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82", "family": "inet6"}
+			]
+		}`))
+	})
+
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		provider string
+		want     string
+	}{
+		{"ec2", "[109.215.101.49]"},
+		{"gcp", "[109.215.101.49 2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82]"},
+		{"hetzner", "[109.215.101.49]"},
+		{"scaleway", "[109.215.101.49 2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82]"},
+	}
+
+	for _, tt := range tests {
+		resolver, err := newMetadataResolver(tt.provider, url.Values{"endpoint": {server.URL}})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tt.provider, err)
+		}
+
+		ipAddrs, err := resolver.Resolve(familyBoth)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.provider, err)
+			continue
+		}
+		if ipAddrs.String() != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.provider, ipAddrs, tt.want)
+		}
+	}
+
+	resolver, _ := newMetadataResolver("hetzner", url.Values{"endpoint": {server.URL}})
+	if _, err := resolver.Resolve(familyV6); err == nil {
+		t.Errorf("expected an error for the IPv6 of a Hetzner instance")
+	}
+
+	if _, err := newMetadataResolver("azure", url.Values{}); err == nil {
+		t.Errorf("expected an error for an unknown provider")
+	}
+}
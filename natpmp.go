@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	natpmpPort    = "5351"
+	natpmpRetries = 4
+	natpmpTimeout = 250 * time.Millisecond
+
+	natpmpVersion = 0
+	pcpVersion    = 2
+
+	natpmpOpExternalAddress = 0
+	pcpOpMap                = 1
+
+	// result code sent by a server which does not speak the requested version
+	resultUnsupportedVersion = 1
+)
+
+const procRoute = "/proc/net/route"
+
+// natpmpResolver asks the default gateway for its external IPv4 using
+// NAT-PMP (RFC 6886), or PCP (RFC 6887) when the gateway only speaks PCP
+type natpmpResolver struct {
+	// gateway is the host:port of the NAT-PMP/PCP server. When empty, the
+	// default gateway is used.
+	gateway string
+}
+
+var _ IPResolver = (*natpmpResolver)(nil)
+
+var errUnsupportedVersion = errors.New("unsupported version")
+
+func newNATPMPResolver(gateway string) (*natpmpResolver, error) {
+	if gateway == "" {
+		return &natpmpResolver{}, nil
+	}
+
+	if _, _, err := net.SplitHostPort(gateway); err != nil {
+		gateway = net.JoinHostPort(gateway, natpmpPort)
+	}
+	return &natpmpResolver{gateway}, nil
+}
+
+func (r *natpmpResolver) Name() string {
+	return "natpmp"
+}
+
+func (r *natpmpResolver) Resolve(families ipFamily) (*IPAddrs, error) {
+	if !families.has(familyV4) {
+		return nil, &noAddressError{"NAT-PMP and PCP only provides the external IPv4"}
+	}
+
+	gateway := r.gateway
+	if gateway == "" {
+		ip, err := defaultGateway(procRoute)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the default gateway, set it with natpmp:<gateway>: %s", err)
+		}
+		gateway = net.JoinHostPort(ip.String(), natpmpPort)
+	}
+
+	conn, err := net.Dial("udp4", gateway)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ip, err := natpmpExternalAddress(conn)
+	if errors.Is(err, errUnsupportedVersion) {
+		ip, err = pcpExternalAddress(conn)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &IPAddrs{V4: &ip}, nil
+}
+
+// natpmpExternalAddress sends a NAT-PMP external address request
+func natpmpExternalAddress(conn net.Conn) (net.IP, error) {
+	res, err := natpmpRoundTrip(conn, []byte{natpmpVersion, natpmpOpExternalAddress}, 0x80|natpmpOpExternalAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res) < 12 {
+		return nil, fmt.Errorf("NAT-PMP response too short: %d bytes", len(res))
+	}
+
+	if code := binary.BigEndian.Uint16(res[2:4]); code != 0 {
+		return nil, fmt.Errorf("NAT-PMP request failed with result code %d", code)
+	}
+
+	return net.IPv4(res[8], res[9], res[10], res[11]), nil
+}
+
+// pcpExternalAddress sends a short-lived PCP MAP request for the discard port
+// and reads the assigned external address. The mapping is deleted afterwards.
+func pcpExternalAddress(conn net.Conn) (net.IP, error) {
+	clientIP := conn.LocalAddr().(*net.UDPAddr).IP
+
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	res, err := natpmpRoundTrip(conn, pcpMapRequest(clientIP, nonce, 60), 0x80|pcpOpMap)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res) < 60 {
+		return nil, fmt.Errorf("PCP response too short: %d bytes", len(res))
+	}
+
+	if code := res[3]; code != 0 {
+		return nil, fmt.Errorf("PCP MAP request failed with result code %d", code)
+	}
+
+	if !bytes.Equal(res[24:36], nonce) {
+		return nil, errors.New("PCP response nonce does not match the request")
+	}
+
+	ip := net.IP(append([]byte(nil), res[44:60]...))
+	if ip.To4() == nil {
+		return nil, fmt.Errorf("PCP server assigned a non IPv4 address: %s", ip)
+	}
+
+	// best effort: the mapping expires anyway
+	_, _ = conn.Write(pcpMapRequest(clientIP, nonce, 0))
+
+	return ip.To4(), nil
+}
+
+func pcpMapRequest(clientIP net.IP, nonce []byte, lifetime uint32) []byte {
+	req := make([]byte, 60)
+	req[0] = pcpVersion
+	req[1] = pcpOpMap
+	binary.BigEndian.PutUint32(req[4:8], lifetime)
+	copy(req[8:24], clientIP.To16())
+	copy(req[24:36], nonce)
+	req[36] = 17 // UDP
+	binary.BigEndian.PutUint16(req[40:42], 9)
+	binary.BigEndian.PutUint16(req[42:44], 9)
+	copy(req[44:60], net.IPv4zero.To16())
+	return req
+}
+
+// natpmpRoundTrip sends req until a response with the expected opcode is
+// received, doubling the timeout after each attempt
+func natpmpRoundTrip(conn net.Conn, req []byte, opcode byte) ([]byte, error) {
+	buf := make([]byte, 1100)
+	timeout := natpmpTimeout
+
+	for attempt := 0; attempt < natpmpRetries; attempt++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+		timeout *= 2
+
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return nil, err
+			}
+
+			if n < 4 {
+				continue
+			}
+
+			// A server that does not speak our version answers with its own.
+			// The result code is the 4th byte in both NAT-PMP and PCP.
+			if buf[0] != req[0] && buf[3] == resultUnsupportedVersion {
+				return nil, errUnsupportedVersion
+			}
+
+			if buf[0] == req[0] && buf[1] == opcode {
+				return append([]byte(nil), buf[:n]...), nil
+			}
+		}
+	}
+
+	return nil, fmt.Errorf("no answer from %s", conn.RemoteAddr())
+}
+
+// defaultGateway reads the IPv4 default gateway from the kernel routing table
+func defaultGateway(path string) (net.IP, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != net.IPv4len {
+			continue
+		}
+
+		// the kernel prints the address in host (little endian) order
+		ip := net.IPv4(raw[3], raw[2], raw[1], raw[0])
+		if !ip.Equal(net.IPv4zero) {
+			return ip, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return nil, errors.New("no default route")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// startNATPMPResponder answers NAT-PMP requests, or only PCP requests when
+// pcpOnly is set, with externalIP
+func startNATPMPResponder(t *testing.T, externalIP string, pcpOnly bool) string {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	ip := net.ParseIP(externalIP)
+
+	go func() {
+		buf := make([]byte, 1100)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			req := buf[:n]
+
+			var res []byte
+			switch {
+			case req[0] == natpmpVersion && pcpOnly:
+				res = make([]byte, 24)
+				res[0] = pcpVersion
+				res[1] = 0x80 | req[1]
+				res[3] = resultUnsupportedVersion
+			case req[0] == natpmpVersion:
+				res = make([]byte, 12)
+				res[1] = 0x80 | natpmpOpExternalAddress
+				binary.BigEndian.PutUint32(res[4:8], 1337)
+				copy(res[8:12], ip.To4())
+			case req[0] == pcpVersion && len(req) == 60:
+				res = make([]byte, 60)
+				res[0] = pcpVersion
+				res[1] = 0x80 | pcpOpMap
+				copy(res[4:8], req[4:8])
+				copy(res[24:44], req[24:44])
+				copy(res[44:60], ip.To16())
+			default:
+				continue
+			}
+
+			_, _ = conn.WriteTo(res, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestNATPMPResolver(t *testing.T) {
+	tests := []struct {
+		name    string
+		pcpOnly bool
+	}{
+		{name: "nat-pmp", pcpOnly: false},
+		{name: "pcp", pcpOnly: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gateway := startNATPMPResponder(t, "109.215.101.49", tt.pcpOnly)
+
+			resolver, err := newNATPMPResolver(gateway)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			ipAddrs, err := resolver.Resolve(familyBoth)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if ipAddrs.V4 == nil || ipAddrs.V4.String() != "109.215.101.49" {
+				t.Errorf("got %s, want [109.215.101.49]", ipAddrs)
+			}
+		})
+	}
+}
+
+func TestDefaultGateway(t *testing.T) {
+	route := "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n" +
+		"eth0\t000200C0\t00000000\t0001\t0\t0\t0\t00FFFFFF\t0\t0\t0\n" +
+		"eth0\t00000000\t010200C0\t0003\t0\t0\t0\t00000000\t0\t0\t0\n"
+
+	path := filepath.Join(t.TempDir(), "route")
+	if err := os.WriteFile(path, []byte(route), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ip, err := defaultGateway(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ip.String() != "192.0.2.1" {
+		t.Errorf("got %s, want 192.0.2.1", ip)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+)
+
+const (
+	openwrtDefaultHost = "192.168.1.1"
+	// openwrtAnonymousSession is the session ID of unauthenticated calls
+	openwrtAnonymousSession = "00000000000000000000000000000000"
+)
+
+// openwrtResolver reads the addresses of the WAN interfaces of an OpenWrt
+// router from the ubus JSON-RPC API of rpcd. When the OPENWRT_PASSWORD
+// environment variable is set, it logs in first.
+type openwrtResolver struct {
+	host     string
+	username string
+	password string
+	// wan and wan6 are the logical interfaces holding the IPv4 and the
+	// IPv6 address
+	wan  string
+	wan6 string
+}
+
+var _ IPResolver = (*openwrtResolver)(nil)
+
+func newOpenwrtResolver(host string, opts url.Values) (*openwrtResolver, error) {
+	if host == "" {
+		host = openwrtDefaultHost
+	}
+
+	r := &openwrtResolver{
+		host:     host,
+		username: opts.Get("user"),
+		password: os.Getenv("OPENWRT_PASSWORD"),
+		wan:      opts.Get("wan"),
+		wan6:     opts.Get("wan6"),
+	}
+
+	if r.username == "" {
+		r.username = "root"
+	}
+	if r.wan == "" {
+		r.wan = "wan"
+	}
+	if r.wan6 == "" {
+		r.wan6 = "wan6"
+	}
+
+	return r, nil
+}
+
+func (r *openwrtResolver) Name() string {
+	return "openwrt:" + r.host
+}
+
+// openwrtInterfaceStatus is the subset of the network.interface status we
+// care about
+type openwrtInterfaceStatus struct {
+	Up          bool `json:"up"`
+	IPv4Address []struct {
+		Address string `json:"address"`
+	} `json:"ipv4-address"`
+	IPv6Address []struct {
+		Address string `json:"address"`
+	} `json:"ipv6-address"`
+}
+
+func (r *openwrtResolver) Resolve(families ipFamily) (*IPAddrs, error) {
+	session := openwrtAnonymousSession
+	if r.password != "" {
+		var err error
+		session, err = r.login()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return resolveFamilies(families, func(family ipFamily) (net.IP, error) {
+		iface := r.wan
+		if family == familyV6 {
+			iface = r.wan6
+		}
+
+		status := openwrtInterfaceStatus{}
+		if err := r.call(session, "network.interface."+iface, "status", map[string]interface{}{}, &status); err != nil {
+			return nil, err
+		}
+
+		addresses := make([]string, 0, len(status.IPv4Address)+len(status.IPv6Address))
+		for _, a := range status.IPv4Address {
+			addresses = append(addresses, a.Address)
+		}
+		for _, a := range status.IPv6Address {
+			addresses = append(addresses, a.Address)
+		}
+
+		for _, address := range addresses {
+			ip := net.ParseIP(address)
+			if ip != nil && (ip.To4() != nil) == (family == familyV4) {
+				return ip, nil
+			}
+		}
+
+		return nil, &noAddressError{fmt.Sprintf("interface %s has no %s address (up=%t)", iface, family, status.Up)}
+	})
+}
+
+// login opens an rpcd session and returns its ID
+func (r *openwrtResolver) login() (string, error) {
+	data := struct {
+		Session string `json:"ubus_rpc_session"`
+	}{}
+
+	parameters := map[string]interface{}{
+		"username": r.username,
+		"password": r.password,
+	}
+
+	if err := r.call(openwrtAnonymousSession, "session", "login", parameters, &data); err != nil {
+		return "", fmt.Errorf("failed to log into OpenWrt: %s", err)
+	}
+
+	if data.Session == "" {
+		return "", fmt.Errorf("failed to log into OpenWrt: no session ID, check OPENWRT_PASSWORD")
+	}
+
+	return data.Session, nil
+}
+
+// openwrtStatusCodes are the ubus status codes, see libubus
+var openwrtStatusCodes = map[int]string{
+	1:  "invalid command",
+	2:  "invalid argument",
+	3:  "method not found",
+	4:  "not found",
+	5:  "no data",
+	6:  "permission denied",
+	7:  "timeout",
+	8:  "not supported",
+	9:  "unknown error",
+	10: "connection failed",
+}
+
+// call invokes method of the ubus object and decodes its result in data
+func (r *openwrtResolver) call(session string, object string, method string, parameters interface{}, data interface{}) error {
+	payload, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "call",
+		"params":  []interface{}{session, object, method, parameters},
+	})
+	if err != nil {
+		return err
+	}
+
+	res, err := defaultHTTP.Post("http://"+r.host+"/ubus", "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("%s.%s failed status=%d response=%s", object, method, res.StatusCode, body)
+	}
+
+	response := struct {
+		Result []json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}{}
+
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("failed to parse %s.%s response=%s: %s", object, method, body, err)
+	}
+
+	if response.Error != nil {
+		return fmt.Errorf("%s.%s failed: %s (%d)", object, method, response.Error.Message, response.Error.Code)
+	}
+
+	// the result is [status] or [status, data]
+	if len(response.Result) == 0 {
+		return fmt.Errorf("%s.%s failed: empty result", object, method)
+	}
+
+	var status int
+	if err := json.Unmarshal(response.Result[0], &status); err != nil {
+		return fmt.Errorf("failed to parse %s.%s status=%s: %s", object, method, response.Result[0], err)
+	}
+
+	if status != 0 {
+		description, ok := openwrtStatusCodes[status]
+		if !ok {
+			description = "unknown status"
+		}
+		return fmt.Errorf("%s.%s failed: %s (%d)", object, method, description, status)
+	}
+
+	if len(response.Result) < 2 {
+		return fmt.Errorf("%s.%s failed: no data", object, method)
+	}
+
+	if err := json.Unmarshal(response.Result[1], data); err != nil {
+		return fmt.Errorf("failed to parse %s.%s data=%s: %s", object, method, response.Result[1], err)
+	}
+
+	return nil
+}
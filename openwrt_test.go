@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenwrtResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ubus" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+
+		call := struct {
+			Params []json.RawMessage `json:"params"`
+		}{}
+		if err := json.NewDecoder(r.Body).Decode(&call); err != nil || len(call.Params) != 4 {
+			t.Fatalf("invalid request: %v", err)
+		}
+
+		var session, object, method string
+		json.Unmarshal(call.Params[0], &session)
+		json.Unmarshal(call.Params[1], &object)
+		json.Unmarshal(call.Params[2], &method)
+
+		switch object + "." + method {
+		case "session.login":
+			credentials := map[string]string{}
+			json.Unmarshal(call.Params[3], &credentials)
+			if credentials["username"] != "root" || credentials["password"] != "secret" {
+				w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":[6]}`))
+				return
+			}
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":[0,{"ubus_rpc_session":"c1ed6c7b025d0caca723a816fa61b668","timeout":300}]}`))
+		case "network.interface.wan.status", "network.interface.wan6.status":
+			if session != "c1ed6c7b025d0caca723a816fa61b668" {
+				w.Write([]byte(`{"jsonrpc":"2.0","id":1,"error":{"code":-32002,"message":"Access denied"}}`))
+				return
+			}
+			if object == "network.interface.wan" {
+				w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":[0,{"up":true,"ipv4-address":[{"address":"109.215.101.49","mask":22}],"ipv6-address":[]}]}`))
+				return
+			}
+			w.Write([]byte(`{"jsonrpc":"2.0","id":1,"result":[0,{"up":true,"ipv4-address":[],"ipv6-address":[{"address":"2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82","mask":64}]}]}`))
+		default:
+			t.Errorf("unexpected call %s.%s", object, method)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	resolver := &openwrtResolver{host: host, username: "root", password: "secret", wan: "wan", wan6: "wan6"}
+	ipAddrs, err := resolver.Resolve(familyBoth)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ipString(ipAddrs.V4) != "109.215.101.49" || ipString(ipAddrs.V6) != "2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82" {
+		t.Errorf("got %s, want [109.215.101.49 2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82]", ipAddrs)
+	}
+
+	resolver = &openwrtResolver{host: host, username: "root", password: "wrong", wan: "wan", wan6: "wan6"}
+	if _, err := resolver.Resolve(familyBoth); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("got %v, want a permission denied error", err)
+	}
+
+	resolver = &openwrtResolver{host: host, wan: "wan", wan6: "wan6"}
+	if _, err := resolver.Resolve(familyV4); err == nil || !strings.Contains(err.Error(), "Access denied") {
+		t.Errorf("got %v, want an access denied error", err)
+	}
+}
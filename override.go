@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// overrideResolver publishes addresses set by hand, with --ipv4 and --ipv6
+// or a pin, instead of detecting them. The other families are resolved by
+// next.
+type overrideResolver struct {
+	ipAddrs *IPAddrs
+	next    IPResolver
+}
+
+var _ IPResolver = (*overrideResolver)(nil)
+
+func (r *overrideResolver) Name() string {
+	return "override," + r.next.Name()
+}
+
+func (r *overrideResolver) Resolve(families ipFamily) (*IPAddrs, error) {
+	resolved := r.ipAddrs.only(families)
+
+	remaining := families &^ resolved.families()
+	if remaining == 0 {
+		return resolved, nil
+	}
+
+	detected, err := r.next.Resolve(remaining)
+	if err != nil {
+		if resolved.families() == 0 {
+			return nil, err
+		}
+
+		// the host has no address of the other families, which is not a
+		// failure
+		var noAddress *noAddressError
+		if errors.As(err, &noAddress) {
+			return resolved, nil
+		}
+
+		// the overridden family is published anyway
+		for _, family := range remaining.split() {
+			resolved.fail(family, err)
+		}
+		return resolved, nil
+	}
+
+	if detected.V4 != nil {
+		resolved.V4 = detected.V4
+	}
+	if detected.V6 != nil {
+		resolved.V6 = detected.V6
+	}
+	for family, err := range detected.failures {
+		resolved.fail(family, err)
+	}
+
+	return resolved, nil
+}
+
+// ipFlag holds an address of family set on the command line
+type ipFlag struct {
+	family ipFamily
+	ip     *net.IP
+}
+
+func (f *ipFlag) String() string {
+	if f.ip == nil {
+		return ""
+	}
+	return f.ip.String()
+}
+
+func (f *ipFlag) Set(value string) error {
+	ip := net.ParseIP(value)
+	if ip == nil || (ip.To4() != nil) != (f.family == familyV4) {
+		return fmt.Errorf("expected an %s address, got %q", f.family, value)
+	}
+	f.ip = &ip
+	return nil
+}
+
+// loadOverrides merges the addresses set on the command line with the active
+// pin of fqdn, the command line wins. An expired pin is removed from the
+// state.
+func loadOverrides(s *state, stateFile string, fqdn string, flags *IPAddrs) (*IPAddrs, error) {
+	now := time.Now()
+	p, expired := s.activePin(fqdn, now)
+	if expired {
+		log.Printf("Pin of %s expired, detecting its address(es) again\n", fqdn)
+		if err := s.save(stateFile); err != nil {
+			return nil, err
+		}
+	}
+
+	overrides := &IPAddrs{V4: flags.V4, V6: flags.V6}
+	if p == nil {
+		return overrides, nil
+	}
+
+	log.Printf("%s is pinned to %s until %s\n", fqdn, p.ipAddrs(), p.Until.Format(time.RFC3339))
+	if overrides.V4 == nil {
+		overrides.V4 = p.V4
+	}
+	if overrides.V6 == nil {
+		overrides.V6 = p.V6
+	}
+	return overrides, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestOverrideResolver(t *testing.T) {
+	manual := net.ParseIP("203.0.113.7")
+	detectedV6 := net.ParseIP("2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82")
+
+	next := &fakeResolver{name: "next", ipAddrs: &IPAddrs{V6: &detectedV6}}
+	resolver := &overrideResolver{&IPAddrs{V4: &manual}, next}
+
+	ipAddrs, err := resolver.Resolve(familyBoth)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ipAddrs.V4.Equal(manual) || !ipAddrs.V6.Equal(detectedV6) {
+		t.Errorf("got %s, want [%s %s]", ipAddrs, manual, detectedV6)
+	}
+	if next.asked != familyV6 {
+		t.Errorf("next was asked for %s, want IPv6", next.asked)
+	}
+
+	next.calls = 0
+	ipAddrs, err = resolver.Resolve(familyV4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ipAddrs.V4.Equal(manual) || ipAddrs.V6 != nil {
+		t.Errorf("got %s, want [%s]", ipAddrs, manual)
+	}
+	if next.calls != 0 {
+		t.Errorf("next was called although every family is overridden")
+	}
+
+	next.ipAddrs, next.err = nil, errors.New("unreachable")
+	ipAddrs, err = resolver.Resolve(familyBoth)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ipAddrs.V4.Equal(manual) || ipAddrs.failed() != familyV6 {
+		t.Errorf("got %s failed=%s, want [%s] failed=IPv6", ipAddrs, ipAddrs.failed(), manual)
+	}
+
+	if _, err := resolver.Resolve(familyV6); err == nil {
+		t.Errorf("expected an error when the detection of the only family fails")
+	}
+
+	// an IPv4-only host: IPv6 is absent, not failed
+	next.err = &noAddressError{"network is unreachable"}
+	ipAddrs, err = resolver.Resolve(familyBoth)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ipAddrs.V4.Equal(manual) || ipAddrs.V6 != nil || ipAddrs.failed() != 0 {
+		t.Errorf("got %s failed=%s, want [%s] without failure", ipAddrs, ipAddrs.failed(), manual)
+	}
+}
+
+func TestIPFlag(t *testing.T) {
+	tests := []struct {
+		family  ipFamily
+		value   string
+		wantErr bool
+	}{
+		{familyV4, "203.0.113.7", false},
+		{familyV4, "2001:db8::1", true},
+		{familyV4, "example.com", true},
+		{familyV6, "2001:db8::1", false},
+		{familyV6, "203.0.113.7", true},
+	}
+
+	for _, tt := range tests {
+		f := &ipFlag{family: tt.family}
+		err := f.Set(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s %s: got error %v, want error %v", tt.family, tt.value, err, tt.wantErr)
+		}
+		if err == nil && f.String() != tt.value {
+			t.Errorf("%s %s: got %s", tt.family, tt.value, f.String())
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// pinRun implements `dyndns pin`: it freezes a record to addresses set by
+// hand until an expiry time, so that the scheduled runs don't overwrite it,
+// and publishes them right away
+func pinRun(args []string) exitCode {
+	var (
+		domainFlag       string
+		recordFlag       string
+		ttlFlag          int = 3600
+		ipv4Flag             = ipFlag{family: familyV4}
+		ipv6Flag             = ipFlag{family: familyV6}
+		untilFlag        string
+		clearFlag        bool
+		stateFileFlag    string = defaultStateFile()
+		providerFlag     string = "gandi"
+		managedValueFlag bool
+		createFlag       string = createRecord
+		allowCIDRFlag    cidrFlag
+		denyCIDRFlag     cidrFlag
+	)
+
+	flags := flag.NewFlagSet("pin", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	flags.StringVar(&domainFlag, "domain", domainFlag, "")
+	flags.StringVar(&recordFlag, "record", recordFlag, "")
+	flags.IntVar(&ttlFlag, "ttl", ttlFlag, "")
+	flags.Var(&ipv4Flag, "ipv4", "")
+	flags.Var(&ipv6Flag, "ipv6", "")
+	flags.StringVar(&untilFlag, "until", untilFlag, "")
+	flags.BoolVar(&clearFlag, "clear", clearFlag, "")
+	flags.StringVar(&stateFileFlag, "state-file", stateFileFlag, "")
+	flags.StringVar(&providerFlag, "provider", providerFlag, "")
+	flags.BoolVar(&managedValueFlag, "managed-value", managedValueFlag, "")
+	flags.StringVar(&createFlag, "create-if-missing", createFlag, "")
+	flags.Var(&allowCIDRFlag, "allow-cidr", "")
+	flags.Var(&denyCIDRFlag, "deny-cidr", "")
+
+	if err := flags.Parse(args); err != nil {
+		log.Printf("error: %v", err)
+		return exitError
+	}
+
+	webhook := os.Getenv("DISCORD_WEBHOOK_URL")
+	if webhook == "" {
+		log.Println("error: required environment variable DISCORD_WEBHOOK_URL is empty or missing")
+		return exitError
+	}
+
+	discordClient := &discordClient{webhook}
+	logErr := log.New(io.MultiWriter(os.Stderr, discordClient), "", 0)
+
+	if domainFlag == "" {
+		logErr.Println("error: required flag --domain is missing")
+		return exitError
+	}
+
+	if recordFlag == "" {
+		logErr.Println("error: required flag --record is missing")
+		return exitError
+	}
+
+	if createFlag != createRecord && createFlag != createFail && createFlag != createAsk {
+		logErr.Printf("error: invalid flag --create-if-missing: expected create, fail or ask, got %s", createFlag)
+		return exitError
+	}
+
+	provider, err := newDNSProvider(providerFlag)
+	if err != nil {
+		log.Printf("error: %v", err)
+		return exitError
+	}
+
+	fqdn := recordFlag + "." + domainFlag
+
+	s, err := loadState(stateFileFlag)
+	if err != nil {
+		logErr.Printf("error: %v", err)
+		return exitError
+	}
+
+	if clearFlag {
+		delete(s.Pins, fqdn)
+		if err := s.save(stateFileFlag); err != nil {
+			logErr.Printf("error: %v", err)
+			return exitError
+		}
+		log.Printf("Pin of %s removed, the next run detects its address(es) again\n", fqdn)
+		return exitOK
+	}
+
+	p := &pin{V4: ipv4Flag.ip, V6: ipv6Flag.ip}
+	if p.V4 == nil && p.V6 == nil {
+		logErr.Println("error: flag --ipv4 or --ipv6 is required")
+		return exitError
+	}
+
+	// the scheduled runs publish the pin, it is held to the same policy as
+	// the detected addresses
+	policy := &ipPolicy{allowCIDRFlag, denyCIDRFlag, onRejectAbort}
+	if _, _, err := policy.filter(p.ipAddrs()); err != nil {
+		logErr.Printf("error: %v", err)
+		return exitError
+	}
+
+	if untilFlag == "" {
+		logErr.Println("error: required flag --until is missing")
+		return exitError
+	}
+
+	p.Until, err = parseUntil(untilFlag, time.Now())
+	if err != nil {
+		logErr.Printf("error: invalid flag --until: %v", err)
+		return exitError
+	}
+
+	s.setPin(fqdn, p)
+	if err := s.save(stateFileFlag); err != nil {
+		logErr.Printf("error: %v", err)
+		return exitError
+	}
+	log.Printf("%s pinned to %s until %s\n", fqdn, p.ipAddrs(), p.Until.Format(time.RFC3339))
+
+	dyn := &DynDNS{
+		provider:        provider,
+		discordClient:   discordClient,
+		families:        p.ipAddrs().families(),
+		staleRecords:    staleKeep,
+		managedValues:   managedValueFlag,
+		state:           s,
+		stateFile:       stateFileFlag,
+		createIfMissing: createFlag,
+	}
+
+	if err := dyn.pin(domainFlag, recordFlag, p, ttlFlag); err != nil {
+		return reportError(err, logErr, discordClient)
+	}
+
+	return exitOK
+}
+
+// parseUntil reads an expiry time, either a RFC 3339 date or a duration
+// from now like 48h
+func parseUntil(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("expected a positive duration, got %s", value)
+		}
+		return now.Add(d), nil
+	}
+
+	until, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("expected a RFC 3339 date like 2006-01-02T15:04:05Z07:00 or a duration like 48h, got %q", value)
+	}
+	if !until.After(now) {
+		return time.Time{}, fmt.Errorf("%s is in the past", value)
+	}
+	return until, nil
+}
+
+// pin publishes the pinned addresses and notifies Discord of the pin
+func (dyndns *DynDNS) pin(domain string, record string, p *pin, ttl int) error {
+	err := dyndns.sync(domain, record, p.ipAddrs(), 0, ttl, false)
+	if err != nil {
+		return err
+	}
+
+	err = dyndns.discordClient.postInfo(&Webhook{
+		Embeds: []Embed{
+			{
+				Title:       fmt.Sprintf("Record %s.%s pinned until %s", record, domain, p.Until.Format(time.RFC3339)),
+				Description: fmt.Sprintf("The scheduled runs publish %s instead of the detected address(es). To resume now, run `dyndns pin --clear --domain %s --record %s`", p.ipAddrs(), domain, record),
+			},
+		},
+	})
+	return errors.Wrap(err, "failed to send message to discord")
+}
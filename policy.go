@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// bogon is a range which must never be published in public DNS
+type bogon struct {
+	ipNet  *net.IPNet
+	reason string
+}
+
+var bogonsV4 = []bogon{
+	mustBogon("0.0.0.0/8", "\"this network\" (RFC 791)"),
+	mustBogon("10.0.0.0/8", "private-use (RFC 1918)"),
+	mustBogon("100.64.0.0/10", "shared address space used by carrier-grade NAT (RFC 6598)"),
+	mustBogon("127.0.0.0/8", "loopback (RFC 1122)"),
+	mustBogon("169.254.0.0/16", "link-local (RFC 3927)"),
+	mustBogon("172.16.0.0/12", "private-use (RFC 1918)"),
+	mustBogon("192.0.0.0/24", "IETF protocol assignments (RFC 6890)"),
+	mustBogon("192.0.2.0/24", "documentation (RFC 5737)"),
+	mustBogon("192.168.0.0/16", "private-use (RFC 1918)"),
+	mustBogon("198.18.0.0/15", "benchmarking (RFC 2544)"),
+	mustBogon("198.51.100.0/24", "documentation (RFC 5737)"),
+	mustBogon("203.0.113.0/24", "documentation (RFC 5737)"),
+	mustBogon("224.0.0.0/4", "multicast (RFC 5771)"),
+	mustBogon("240.0.0.0/4", "reserved (RFC 1112)"),
+}
+
+var bogonsV6 = []bogon{
+	mustBogon("::/8", "reserved, including loopback and IPv4-mapped addresses (RFC 4291)"),
+	mustBogon("100::/64", "discard-only (RFC 6666)"),
+	mustBogon("2001:2::/48", "benchmarking (RFC 5180)"),
+	mustBogon("2001:db8::/32", "documentation (RFC 3849)"),
+	mustBogon("fc00::/7", "unique local (RFC 4193)"),
+	mustBogon("fe80::/10", "link-local (RFC 4291)"),
+	mustBogon("fec0::/10", "site-local (RFC 3879)"),
+	mustBogon("ff00::/8", "multicast (RFC 4291)"),
+	// anything else outside the global unicast space is unallocated
+	mustBogon("::/3", "outside the global unicast range 2000::/3"),
+	mustBogon("4000::/2", "outside the global unicast range 2000::/3"),
+	mustBogon("8000::/1", "outside the global unicast range 2000::/3"),
+}
+
+func mustBogon(cidr string, reason string) bogon {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return bogon{ipNet, reason}
+}
+
+// What to do with an address rejected by the ipPolicy
+const (
+	onRejectAbort = "abort"
+	onRejectSkip  = "skip"
+)
+
+// ipPolicy is the validation layer between the detection and the update of
+// the DNS records. It rejects the bogons, unless explicitly allowed.
+type ipPolicy struct {
+	// allow are the ranges accepted even if they are bogons or denied
+	allow cidrFlag
+	// deny are the ranges rejected on top of the bogons
+	deny cidrFlag
+	// onReject is either onRejectAbort or onRejectSkip
+	onReject string
+}
+
+// rejectedIPError explains why an address was rejected
+type rejectedIPError struct {
+	ip     net.IP
+	reason string
+}
+
+func (e *rejectedIPError) Error() string {
+	return fmt.Sprintf("refusing to publish %s: %s", e.ip, e.reason)
+}
+
+// check returns a *rejectedIPError if ip must not be published
+func (p *ipPolicy) check(ip net.IP) error {
+	for _, ipNet := range p.allow {
+		if ipNet.Contains(ip) {
+			return nil
+		}
+	}
+
+	for _, ipNet := range p.deny {
+		if ipNet.Contains(ip) {
+			return &rejectedIPError{ip, fmt.Sprintf("%s is denied by --deny-cidr", ipNet)}
+		}
+	}
+
+	bogons := bogonsV6
+	if ip.To4() != nil {
+		bogons = bogonsV4
+	}
+
+	for _, bogon := range bogons {
+		if bogon.ipNet.Contains(ip) {
+			return &rejectedIPError{ip, fmt.Sprintf("%s is %s", bogon.ipNet, bogon.reason)}
+		}
+	}
+
+	return nil
+}
+
+// filter applies the policy to each family. In skip mode, the rejected
+// families are removed from the returned IPAddrs and their errors returned
+// alongside. In abort mode, the first rejection is returned as error. The
+// failed families are kept as is.
+func (p *ipPolicy) filter(ipAddrs *IPAddrs) (*IPAddrs, []error, error) {
+	filtered := &IPAddrs{failures: ipAddrs.failures}
+	rejected := make([]error, 0, 2)
+
+	if ipAddrs.V4 != nil {
+		if err := p.check(*ipAddrs.V4); err != nil {
+			rejected = append(rejected, err)
+		} else {
+			filtered.V4 = ipAddrs.V4
+		}
+	}
+
+	if ipAddrs.V6 != nil {
+		if err := p.check(*ipAddrs.V6); err != nil {
+			rejected = append(rejected, err)
+		} else {
+			filtered.V6 = ipAddrs.V6
+		}
+	}
+
+	if len(rejected) > 0 && p.onReject != onRejectSkip {
+		return nil, nil, rejected[0]
+	}
+
+	if filtered.V4 == nil && filtered.V6 == nil {
+		return nil, nil, fmt.Errorf("no address left to publish: %v", rejected)
+	}
+
+	return filtered, rejected, nil
+}
+
+// cidrFlag collects the values of a (repeatable) comma-separated CIDR flag
+type cidrFlag []*net.IPNet
+
+func (f *cidrFlag) String() string {
+	cidrs := make([]string, 0, len(*f))
+	for _, ipNet := range *f {
+		cidrs = append(cidrs, ipNet.String())
+	}
+	return strings.Join(cidrs, ",")
+}
+
+func (f *cidrFlag) Set(value string) error {
+	for _, cidr := range strings.Split(value, ",") {
+		_, ipNet, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return err
+		}
+		*f = append(*f, ipNet)
+	}
+	return nil
+}
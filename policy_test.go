@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestIPPolicyCheck(t *testing.T) {
+	var allow, deny cidrFlag
+	if err := allow.Set("192.168.1.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	if err := deny.Set("109.215.0.0/16,2a01:cb19::/32"); err != nil {
+		t.Fatal(err)
+	}
+	policy := &ipPolicy{allow: allow, deny: deny}
+
+	tests := []struct {
+		ip       string
+		rejected bool
+	}{
+		{ip: "80.67.169.12", rejected: false},
+		{ip: "192.168.1.10", rejected: false},
+		{ip: "192.168.2.10", rejected: true},
+		{ip: "10.0.0.1", rejected: true},
+		{ip: "100.64.12.1", rejected: true},
+		{ip: "127.0.0.1", rejected: true},
+		{ip: "109.215.101.49", rejected: true},
+		{ip: "2a00:1450:4007:80c::200e", rejected: false},
+		{ip: "2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82", rejected: true},
+		{ip: "fd00::2", rejected: true},
+		{ip: "fe80::1", rejected: true},
+		{ip: "::1", rejected: true},
+		{ip: "::ffff:192.168.2.10", rejected: true},
+		{ip: "0:cb19:96a:7c00:13b0:5ba3:16ae:6c82", rejected: true},
+		{ip: "2001:db8::1", rejected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			err := policy.check(net.ParseIP(tt.ip))
+
+			var rejectedErr *rejectedIPError
+			if tt.rejected != errors.As(err, &rejectedErr) {
+				t.Errorf("got %v, want rejected=%v", err, tt.rejected)
+			}
+		})
+	}
+}
+
+func TestIPPolicyFilter(t *testing.T) {
+	v4 := net.ParseIP("80.67.169.12")
+	v6 := net.ParseIP("fd00::2")
+	ipAddrs := &IPAddrs{V4: &v4, V6: &v6}
+
+	_, _, err := (&ipPolicy{onReject: onRejectAbort}).filter(ipAddrs)
+	if err == nil {
+		t.Errorf("expected an error in abort mode")
+	}
+
+	filtered, rejected, err := (&ipPolicy{onReject: onRejectSkip}).filter(ipAddrs)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if filtered.V4 != &v4 || filtered.V6 != nil {
+		t.Errorf("got %s, want [%s]", filtered, v4)
+	}
+	if len(rejected) != 1 {
+		t.Errorf("got %d rejected addresses, want 1", len(rejected))
+	}
+}
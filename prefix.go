@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// lanHost is a machine of the LAN whose AAAA record follows the delegated
+// prefix: its address is the detected prefix followed by a static interface
+// identifier
+type lanHost struct {
+	record string
+	// iid holds the interface identifier in the bits after the prefix
+	iid net.IP
+}
+
+// address combines the first prefixLength bits of prefix with the interface
+// identifier of the host
+func (h *lanHost) address(prefix net.IP, prefixLength int) net.IP {
+	mask := net.CIDRMask(prefixLength, 8*net.IPv6len)
+	prefix = prefix.To16()
+
+	ip := make(net.IP, net.IPv6len)
+	for i := range ip {
+		ip[i] = prefix[i]&mask[i] | h.iid[i]&^mask[i]
+	}
+	return ip
+}
+
+// lanHostFlag collects the values of the (repeatable) comma-separated
+// --lan-host flag, formatted as record=interface-identifier
+type lanHostFlag []*lanHost
+
+func (f *lanHostFlag) String() string {
+	hosts := make([]string, 0, len(*f))
+	for _, host := range *f {
+		hosts = append(hosts, host.record+"="+host.iid.String())
+	}
+	return strings.Join(hosts, ",")
+}
+
+func (f *lanHostFlag) Set(value string) error {
+	for _, host := range strings.Split(value, ",") {
+		record, iid, ok := strings.Cut(strings.TrimSpace(host), "=")
+		if !ok || record == "" {
+			return fmt.Errorf("invalid host %q, expected record=interface-identifier", host)
+		}
+
+		ip := net.ParseIP(iid)
+		if ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid interface identifier %q for %s, expected an IPv6 like ::1:2:3:4", iid, record)
+		}
+
+		*f = append(*f, &lanHost{record, ip})
+	}
+	return nil
+}
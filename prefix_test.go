@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestLanHostAddress(t *testing.T) {
+	var hosts lanHostFlag
+	if err := hosts.Set("nas=::1:2:3:4,pi=::ba27:ebff:fe12:3456"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := hosts.Set("lab=::2a:0:0:0:1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	prefix := net.ParseIP("2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82")
+
+	tests := []struct {
+		host         *lanHost
+		prefixLength int
+		want         string
+	}{
+		{host: hosts[0], prefixLength: 64, want: "2a01:cb19:96a:7c00:1:2:3:4"},
+		{host: hosts[1], prefixLength: 64, want: "2a01:cb19:96a:7c00:ba27:ebff:fe12:3456"},
+		{host: hosts[0], prefixLength: 56, want: "2a01:cb19:96a:7c00:1:2:3:4"},
+		{host: hosts[2], prefixLength: 56, want: "2a01:cb19:96a:7c2a:0:0:0:1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host.record, func(t *testing.T) {
+			got := tt.host.address(prefix, tt.prefixLength)
+			if !got.Equal(net.ParseIP(tt.want)) {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	for _, invalid := range []string{"nas", "=::1", "nas=1.2.3.4", "nas=foo"} {
+		var f lanHostFlag
+		if err := f.Set(invalid); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// rrset is the set of records of one type at a name
+type rrset struct {
+	Type   string
+	TTL    int
+	Values []*net.IP
+}
+
+// DNSProvider manages the records of the zones hosted by a DNS provider
+type DNSProvider interface {
+	// Name identifies the provider in logs and notifications
+	Name() string
+	// ConsoleURL links to the records of domain in the web console of the
+	// provider
+	ConsoleURL(domain string) string
+	// List returns the A and AAAA rrsets of name
+	List(domain string, name string) ([]*rrset, error)
+	// Update creates or replaces the rrsets of name in upserts, one per type,
+	// and deletes the rrsets of the types in deletes. The rrsets of the other
+	// types are left untouched.
+	Update(domain string, name string, upserts []*rrset, deletes []string) error
+}
+
+// newDNSProvider returns the provider called name, configured from the
+// environment
+func newDNSProvider(name string) (DNSProvider, error) {
+	switch name {
+	case "gandi":
+		token := os.Getenv("GANDI_TOKEN")
+		if token == "" {
+			return nil, fmt.Errorf("required environment variable GANDI_TOKEN is empty or missing")
+		}
+		return &gandiClient{token}, nil
+	}
+
+	return nil, fmt.Errorf("unknown provider %q, expected gandi", name)
+}
+
+// Kinds of the errors returned by the API of a DNS provider
+var (
+	errUnauthorized   = errors.New("unauthorized")
+	errForbidden      = errors.New("forbidden")
+	errDomainNotFound = errors.New("domain not found")
+	errRecordNotFound = errors.New("record not found")
+	errRateLimited    = errors.New("rate limited")
+	errServerError    = errors.New("server error")
+)
+
+// providerError is an error response of the API of a DNS provider
+type providerError struct {
+	provider string
+	// kind is one of the errors above
+	kind   error
+	status int
+	// message is the explanation given by the API
+	message string
+	// hint tells the user how to fix it
+	hint string
+}
+
+func (e *providerError) Error() string {
+	return fmt.Sprintf("%s: %s, %s (status=%d message=%s)", e.provider, e.kind, e.hint, e.status, e.message)
+}
+
+func (e *providerError) Unwrap() error {
+	return e.kind
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// quorumResolver queries all its resolvers concurrently and only trusts an
+// address when at least quorum of them agree on it
+type quorumResolver struct {
+	resolvers []IPResolver
+	quorum    int
+}
+
+var _ IPResolver = (*quorumResolver)(nil)
+
+// quorumAnswer is what a single resolver answered
+type quorumAnswer struct {
+	name    string
+	ipAddrs *IPAddrs
+	err     error
+}
+
+// quorumError is returned when the resolvers disagree
+type quorumError struct {
+	family  string
+	quorum  int
+	answers []quorumAnswer
+}
+
+func (e *quorumError) Error() string {
+	lines := make([]string, 0, len(e.answers)+1)
+	lines = append(lines, fmt.Sprintf("no quorum for %s: %d of %d ip sources must agree", e.family, e.quorum, len(e.answers)))
+
+	for _, answer := range e.answers {
+		if answer.err != nil {
+			lines = append(lines, fmt.Sprintf("  %s: error: %v", answer.name, answer.err))
+		} else {
+			lines = append(lines, fmt.Sprintf("  %s: %s", answer.name, answer.ipAddrs))
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func newQuorumResolver(resolvers []IPResolver, quorum int) (*quorumResolver, error) {
+	if quorum < 1 || quorum > len(resolvers) {
+		return nil, fmt.Errorf("quorum must be between 1 and the number of ip sources (%d), got %d", len(resolvers), quorum)
+	}
+	return &quorumResolver{resolvers, quorum}, nil
+}
+
+func (r *quorumResolver) Name() string {
+	return fmt.Sprintf("quorum(%d/%d)", r.quorum, len(r.resolvers))
+}
+
+func (r *quorumResolver) Resolve(families ipFamily) (*IPAddrs, error) {
+	answers := make([]quorumAnswer, len(r.resolvers))
+
+	var wg sync.WaitGroup
+	for i, resolver := range r.resolvers {
+		wg.Add(1)
+		go func(i int, resolver IPResolver) {
+			defer wg.Done()
+			ipAddrs, err := resolver.Resolve(families)
+			answers[i] = quorumAnswer{resolver.Name(), ipAddrs, err}
+		}(i, resolver)
+	}
+	wg.Wait()
+
+	// each family is elected on its own: a disagreement on one of them
+	// does not prevent the other from being updated
+	ipAddrs := &IPAddrs{}
+	var firstErr error
+
+	for _, family := range families.split() {
+		get := func(ipAddrs *IPAddrs) *net.IP { return ipAddrs.V4 }
+		if family == familyV6 {
+			get = func(ipAddrs *IPAddrs) *net.IP { return ipAddrs.V6 }
+		}
+
+		ip, err := r.elect(family.String(), answers, get)
+		if err != nil {
+			ipAddrs.fail(family, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if family == familyV4 {
+			ipAddrs.V4 = ip
+		} else {
+			ipAddrs.V6 = ip
+		}
+	}
+
+	if ipAddrs.V4 == nil && ipAddrs.V6 == nil {
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		return nil, &quorumError{"IPv4 or IPv6", r.quorum, answers}
+	}
+
+	return ipAddrs, nil
+}
+
+// elect returns the address of a family that gathered the quorum. It returns
+// nil when no resolver found an address of this family.
+func (r *quorumResolver) elect(family string, answers []quorumAnswer, get func(*IPAddrs) *net.IP) (*net.IP, error) {
+	votes := make(map[string]int)
+	candidates := make(map[string]*net.IP)
+
+	for _, answer := range answers {
+		if answer.err != nil || answer.ipAddrs == nil {
+			continue
+		}
+
+		ip := get(answer.ipAddrs)
+		if ip == nil {
+			continue
+		}
+
+		votes[ip.String()]++
+		candidates[ip.String()] = ip
+	}
+
+	if len(votes) == 0 {
+		return nil, nil
+	}
+
+	keys := make([]string, 0, len(votes))
+	for key := range votes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return votes[keys[i]] > votes[keys[j]] })
+
+	// a tie means the resolvers disagree, even when both reach the quorum
+	if votes[keys[0]] < r.quorum || (len(keys) > 1 && votes[keys[0]] == votes[keys[1]]) {
+		return nil, &quorumError{family, r.quorum, answers}
+	}
+
+	return candidates[keys[0]], nil
+}
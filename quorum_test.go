@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestQuorumResolver(t *testing.T) {
+	ip1 := net.ParseIP("109.215.101.49")
+	ip2 := net.ParseIP("203.0.113.7")
+	ip6 := net.ParseIP("2001:db8::1")
+	ip6b := net.ParseIP("2001:db8::2")
+
+	tests := []struct {
+		name      string
+		resolvers []IPResolver
+		quorum    int
+		wantV4    string
+		wantV6    string
+		// wantFailed are the families without quorum
+		wantFailed ipFamily
+		wantErr    bool
+	}{
+		{
+			name: "agree",
+			resolvers: []IPResolver{
+				&fakeResolver{name: "a", ipAddrs: &IPAddrs{V4: &ip1, V6: &ip6}},
+				&fakeResolver{name: "b", ipAddrs: &IPAddrs{V4: &ip1, V6: &ip6}},
+				&fakeResolver{name: "c", ipAddrs: &IPAddrs{V4: &ip2}},
+			},
+			quorum: 2,
+			wantV4: "109.215.101.49",
+			wantV6: "2001:db8::1",
+		},
+		{
+			name: "one failure is tolerated",
+			resolvers: []IPResolver{
+				&fakeResolver{name: "a", ipAddrs: &IPAddrs{V4: &ip1}},
+				&fakeResolver{name: "b", err: errors.New("timeout")},
+				&fakeResolver{name: "c", ipAddrs: &IPAddrs{V4: &ip1}},
+			},
+			quorum: 2,
+			wantV4: "109.215.101.49",
+		},
+		{
+			name: "disagree",
+			resolvers: []IPResolver{
+				&fakeResolver{name: "a", ipAddrs: &IPAddrs{V4: &ip1}},
+				&fakeResolver{name: "b", ipAddrs: &IPAddrs{V4: &ip2}},
+				&fakeResolver{name: "c", err: errors.New("timeout")},
+			},
+			quorum:  2,
+			wantErr: true,
+		},
+		{
+			name: "disagree on IPv6 only",
+			resolvers: []IPResolver{
+				&fakeResolver{name: "a", ipAddrs: &IPAddrs{V4: &ip1, V6: &ip6}},
+				&fakeResolver{name: "b", ipAddrs: &IPAddrs{V4: &ip1, V6: &ip6b}},
+			},
+			quorum:     2,
+			wantV4:     "109.215.101.49",
+			wantFailed: familyV6,
+		},
+		{
+			name: "tie",
+			resolvers: []IPResolver{
+				&fakeResolver{name: "a", ipAddrs: &IPAddrs{V4: &ip1}},
+				&fakeResolver{name: "b", ipAddrs: &IPAddrs{V4: &ip2}},
+			},
+			quorum:  1,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver, err := newQuorumResolver(tt.resolvers, tt.quorum)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			ipAddrs, err := resolver.Resolve(familyBoth)
+			if tt.wantErr {
+				var quorumErr *quorumError
+				if !errors.As(err, &quorumErr) {
+					t.Fatalf("got %v, want a quorum error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := ipString(ipAddrs.V4); got != tt.wantV4 {
+				t.Errorf("got V4 %s, want %s", got, tt.wantV4)
+			}
+			if got := ipString(ipAddrs.V6); got != tt.wantV6 {
+				t.Errorf("got V6 %s, want %s", got, tt.wantV6)
+			}
+			if got := ipAddrs.failed(); got != tt.wantFailed {
+				t.Errorf("got failed families %d, want %d", got, tt.wantFailed)
+			}
+		})
+	}
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// ipFamily is a set of address families
+type ipFamily int
+
+const (
+	familyV4 ipFamily = 1 << iota
+	familyV6
+
+	familyBoth = familyV4 | familyV6
+)
+
+// has reports whether all the families of other are in f
+func (f ipFamily) has(other ipFamily) bool {
+	return f&other == other
+}
+
+// split returns each family of f, IPv4 first
+func (f ipFamily) split() []ipFamily {
+	families := make([]ipFamily, 0, 2)
+	for _, family := range []ipFamily{familyV4, familyV6} {
+		if f.has(family) {
+			families = append(families, family)
+		}
+	}
+	return families
+}
+
+func (f ipFamily) String() string {
+	switch f {
+	case familyV4:
+		return "IPv4"
+	case familyV6:
+		return "IPv6"
+	}
+	return "IPv4 and IPv6"
+}
+
+// IPResolver finds the current public IP address(es) of the host
+type IPResolver interface {
+	// Name identifies the resolver in logs and notifications
+	Name() string
+	// Resolve looks for the addresses of the given families. It succeeds as
+	// long as at least one address is found. When the detection worked but
+	// the host has no address of the families, the error is a
+	// *noAddressError.
+	Resolve(families ipFamily) (*IPAddrs, error)
+}
+
+// noAddressError is returned by the resolvers when the host has no address
+// of the families asked, or when the resolver cannot provide them. Unlike a
+// failure, it means that the records of these families are stale.
+type noAddressError struct {
+	reason string
+}
+
+func (e *noAddressError) Error() string {
+	return e.reason
+}
+
+// unreachable reports whether err is a dial error meaning that the host has
+// no route or no source address for the family of the dial: it has no
+// address of this family, as opposed to a service being down.
+func unreachable(err error) bool {
+	return errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.EADDRNOTAVAIL)
+}
+
+// resolveFamilies asks lookup for the address of each family of families,
+// and tells the families without address apart from the failed ones: lookup
+// returns a *noAddressError, or fails to dial since the host has no route for
+// the family.
+func resolveFamilies(families ipFamily, lookup func(family ipFamily) (net.IP, error)) (*IPAddrs, error) {
+	ipAddrs := &IPAddrs{}
+	errs := make([]string, 0, 2)
+	absent := make([]string, 0, 2)
+
+	for _, family := range families.split() {
+		ip, err := lookup(family)
+
+		var noAddress *noAddressError
+		if errors.As(err, &noAddress) || unreachable(err) {
+			// the host has no address of this family, which is not a
+			// failure
+			absent = append(absent, fmt.Sprintf("%s: %s", family, err))
+			continue
+		}
+
+		if err == nil {
+			err = ipAddrs.set(family, ip)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", family, err))
+			ipAddrs.fail(family, err)
+		}
+	}
+
+	if ipAddrs.V4 == nil && ipAddrs.V6 == nil {
+		if len(errs) == 0 {
+			return nil, &noAddressError{strings.Join(absent, "; ")}
+		}
+		return nil, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+
+	return ipAddrs, nil
+}
+
+// resolverChain is an ordered list of fallbacks: the first resolver to
+// succeed wins. The families it does not provide, because it failed for them
+// or does not support them, are asked to the next resolvers. A family still
+// missing at the end is failed if a resolver failed for it, and has no
+// address otherwise.
+type resolverChain []IPResolver
+
+var _ IPResolver = (resolverChain)(nil)
+
+func (chain resolverChain) Name() string {
+	names := make([]string, 0, len(chain))
+	for _, resolver := range chain {
+		names = append(names, resolver.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (chain resolverChain) Resolve(families ipFamily) (*IPAddrs, error) {
+	errs := make([]string, 0, len(chain))
+	resolved := &IPAddrs{}
+
+	missing := families
+	for _, resolver := range chain {
+		if missing == 0 {
+			break
+		}
+
+		ipAddrs, err := resolver.Resolve(missing)
+
+		var noAddress *noAddressError
+		if errors.As(err, &noAddress) {
+			log.Printf("ip source %s found no %s address: %v\n", resolver.Name(), missing, err)
+			continue
+		}
+
+		if err != nil {
+			log.Printf("warning: ip source %s failed: %v\n", resolver.Name(), err)
+			errs = append(errs, fmt.Sprintf("%s: %v", resolver.Name(), err))
+			for _, family := range missing.split() {
+				resolved.fail(family, err)
+			}
+			continue
+		}
+
+		ipAddrs = ipAddrs.only(missing)
+		for family, err := range ipAddrs.failures {
+			log.Printf("warning: ip source %s failed for %s: %v\n", resolver.Name(), family, err)
+			errs = append(errs, fmt.Sprintf("%s: %s: %v", resolver.Name(), family, err))
+			resolved.fail(family, err)
+		}
+
+		if ipAddrs.V4 != nil {
+			resolved.V4 = ipAddrs.V4
+			delete(resolved.failures, familyV4)
+		}
+		if ipAddrs.V6 != nil {
+			resolved.V6 = ipAddrs.V6
+			delete(resolved.failures, familyV6)
+		}
+
+		missing = families &^ resolved.families()
+	}
+
+	if resolved.V4 != nil || resolved.V6 != nil {
+		return resolved, nil
+	}
+
+	if len(errs) == 0 {
+		return nil, &noAddressError{fmt.Sprintf("no %s address found by %s", families, chain.Name())}
+	}
+
+	return nil, fmt.Errorf("failed to resolve the current IP(s): %s", strings.Join(errs, "; "))
+}
+
+// rawIPSources are the prefixes of the sources whose argument is taken
+// verbatim, up to the end of the --ip-source value: commas, question marks
+// and all
+var rawIPSources = []string{"exec:", "http:"}
+
+// newIPResolver returns the resolver described by source, formatted as
+// name[:argument][?option=value&...]
+func newIPResolver(source string) (IPResolver, error) {
+	switch {
+	case strings.HasPrefix(source, "exec:"):
+		return newExecResolver(strings.TrimPrefix(source, "exec:"))
+	case strings.HasPrefix(source, "http:"):
+		return newHTTPEchoResolver(strings.TrimPrefix(source, "http:"))
+	}
+
+	name, arg, opts, err := parseIPSource(source)
+	if err != nil {
+		return nil, err
+	}
+
+	switch name {
+	case "interface":
+		return newInterfaceResolver(arg, opts)
+	case "upnp":
+		return newUPnPResolver(arg)
+	case "natpmp":
+		return newNATPMPResolver(arg)
+	case "dns":
+		return newDNSEchoResolver(arg, opts)
+	case "stun":
+		return newSTUNResolver(arg)
+	case "livebox":
+		return newLiveboxResolver(arg, opts)
+	case "fritzbox":
+		return newFritzboxResolver(arg, opts)
+	case "openwrt":
+		return newOpenwrtResolver(arg, opts)
+	case "snmp":
+		return newSNMPResolver(arg, opts)
+	case "metadata":
+		return newMetadataResolver(arg, opts)
+	case "ipify":
+		return ipifyResolver, nil
+	case "icanhazip":
+		return icanhazipResolver, nil
+	case "ifconfig.co":
+		return ifconfigCoResolver, nil
+	}
+
+	return nil, fmt.Errorf("unknown ip source %q", source)
+}
+
+// parseIPSource splits an --ip-source value into its name, argument and options
+func parseIPSource(source string) (name string, arg string, opts url.Values, err error) {
+	source, rawOpts, _ := strings.Cut(source, "?")
+	name, arg, _ = strings.Cut(source, ":")
+
+	opts, err = url.ParseQuery(rawOpts)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("invalid options for ip source %s: %s", name, err)
+	}
+
+	return name, arg, opts, nil
+}
+
+// boolOption reads the boolean option key. It defaults to false.
+func boolOption(opts url.Values, key string) (bool, error) {
+	if !opts.Has(key) {
+		return false, nil
+	}
+
+	value, err := strconv.ParseBool(opts.Get(key))
+	if err != nil {
+		return false, fmt.Errorf("invalid option %s=%s: expected true or false", key, opts.Get(key))
+	}
+	return value, nil
+}
+
+// newResolverChain builds a resolverChain from a list of sources
+func newResolverChain(sources []string) (resolverChain, error) {
+	chain := make(resolverChain, 0, len(sources))
+
+	for _, source := range sources {
+		resolver, err := newIPResolver(source)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, resolver)
+	}
+
+	return chain, nil
+}
+
+// ipSourceFlag collects the values of the (repeatable) --ip-source flag
+type ipSourceFlag []string
+
+func (f *ipSourceFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *ipSourceFlag) Set(value string) error {
+	remaining := value
+	for {
+		source, rest, more := strings.Cut(remaining, ",")
+		for _, prefix := range rawIPSources {
+			if strings.HasPrefix(strings.TrimSpace(remaining), prefix) {
+				source, more = remaining, false
+				break
+			}
+		}
+
+		source = strings.TrimSpace(source)
+		if source == "" {
+			return fmt.Errorf("empty ip source in %q", value)
+		}
+		*f = append(*f, source)
+
+		if !more {
+			return nil
+		}
+		remaining = rest
+	}
+}
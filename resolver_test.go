@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+)
+
+type fakeResolver struct {
+	name    string
+	ipAddrs *IPAddrs
+	err     error
+	calls   int
+	// asked are the families of the last call
+	asked ipFamily
+}
+
+func (r *fakeResolver) Name() string {
+	return r.name
+}
+
+func (r *fakeResolver) Resolve(families ipFamily) (*IPAddrs, error) {
+	r.calls++
+	r.asked = families
+	return r.ipAddrs, r.err
+}
+
+// ipString formats ip, which may be nil
+func ipString(ip *net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+func TestResolverChainFallback(t *testing.T) {
+	ip := net.ParseIP("109.215.101.49")
+	ip6 := net.ParseIP("2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82")
+
+	first := &fakeResolver{name: "first", err: errors.New("unreachable")}
+	second := &fakeResolver{name: "second", ipAddrs: &IPAddrs{V4: &ip, V6: &ip6}}
+	third := &fakeResolver{name: "third", err: errors.New("should not be called")}
+
+	ipAddrs, err := resolverChain{first, second, third}.Resolve(familyBoth)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ipAddrs.V4.Equal(ip) {
+		t.Errorf("got %s, want %s", ipAddrs, ip)
+	}
+	if third.calls != 0 {
+		t.Errorf("resolver %s was called after a successful one", third.name)
+	}
+
+	_, err = resolverChain{first, third}.Resolve(familyBoth)
+	if err == nil {
+		t.Errorf("expected an error when all the resolvers fail")
+	}
+}
+
+func TestResolverChainFamilyFallback(t *testing.T) {
+	ip4 := net.ParseIP("109.215.101.49")
+	ip6 := net.ParseIP("2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82")
+
+	partial := &IPAddrs{V4: &ip4}
+	partial.fail(familyV6, errors.New("no route to host"))
+
+	first := &fakeResolver{name: "first", ipAddrs: partial}
+	second := &fakeResolver{name: "second", ipAddrs: &IPAddrs{V6: &ip6}}
+
+	ipAddrs, err := resolverChain{first, second}.Resolve(familyBoth)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if second.asked != familyV6 {
+		t.Errorf("second resolver asked for %s, want IPv6", second.asked)
+	}
+	if ipAddrs.String() != "[109.215.101.49 2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82]" || ipAddrs.failed() != 0 {
+		t.Errorf("got %s failed=%s, want both addresses", ipAddrs, ipAddrs.failed())
+	}
+
+	partial = &IPAddrs{V4: &ip4}
+	partial.fail(familyV6, errors.New("no route to host"))
+	first = &fakeResolver{name: "first", ipAddrs: partial}
+	third := &fakeResolver{name: "third", err: errors.New("unreachable")}
+
+	ipAddrs, err = resolverChain{first, third}.Resolve(familyBoth)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ipString(ipAddrs.V4) != "109.215.101.49" || ipAddrs.failed() != familyV6 {
+		t.Errorf("got %s failed=%s, want the IPv4 and a failed IPv6", ipAddrs, ipAddrs.failed())
+	}
+}
+
+func TestResolverChainMissingFamily(t *testing.T) {
+	ip4 := net.ParseIP("109.215.101.49")
+	ip6 := net.ParseIP("2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82")
+
+	// an IPv4-only source, like upnp, does not fail for IPv6
+	first := &fakeResolver{name: "upnp", ipAddrs: &IPAddrs{V4: &ip4}}
+	second := &fakeResolver{name: "second", ipAddrs: &IPAddrs{V6: &ip6}}
+
+	ipAddrs, err := resolverChain{first, second}.Resolve(familyBoth)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if second.asked != familyV6 {
+		t.Errorf("second resolver asked for %s, want IPv6", second.asked)
+	}
+	if ipAddrs.String() != "[109.215.101.49 2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82]" || ipAddrs.failed() != 0 {
+		t.Errorf("got %s failed=%s, want both addresses", ipAddrs, ipAddrs.failed())
+	}
+
+	// no source has an IPv6 address: the family is absent, not failed
+	third := &fakeResolver{name: "third", err: &noAddressError{"no IPv6 address"}}
+
+	ipAddrs, err = resolverChain{first, third}.Resolve(familyBoth)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ipAddrs.String() != "[109.215.101.49]" || ipAddrs.failed() != 0 {
+		t.Errorf("got %s failed=%s, want the IPv4 only", ipAddrs, ipAddrs.failed())
+	}
+
+	var noAddress *noAddressError
+	if _, err := (resolverChain{third}).Resolve(familyV6); !errors.As(err, &noAddress) {
+		t.Errorf("got %v, want a *noAddressError", err)
+	}
+}
+
+func TestResolveFamilies(t *testing.T) {
+	ip4 := net.ParseIP("109.215.101.49")
+	noRoute := &net.OpError{Op: "dial", Net: "udp6", Err: os.NewSyscallError("connect", syscall.ENETUNREACH)}
+
+	// no route for IPv6: absent
+	ipAddrs, err := resolveFamilies(familyBoth, func(family ipFamily) (net.IP, error) {
+		if family == familyV6 {
+			return nil, noRoute
+		}
+		return ip4, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ipAddrs.String() != "[109.215.101.49]" || ipAddrs.failed() != 0 {
+		t.Errorf("got %s failed=%s, want the IPv4 only", ipAddrs, ipAddrs.failed())
+	}
+
+	// the service is down for IPv6: failed
+	ipAddrs, err = resolveFamilies(familyBoth, func(family ipFamily) (net.IP, error) {
+		if family == familyV6 {
+			return nil, errors.New("status=503")
+		}
+		return ip4, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ipAddrs.failed() != familyV6 {
+		t.Errorf("got failed=%s, want IPv6", ipAddrs.failed())
+	}
+
+	// an address of the wrong family is a failure too
+	if _, err := resolveFamilies(familyV6, func(ipFamily) (net.IP, error) { return ip4, nil }); err == nil {
+		t.Errorf("expected an error for an IPv4 returned as IPv6")
+	}
+
+	var noAddress *noAddressError
+	_, err = resolveFamilies(familyV6, func(ipFamily) (net.IP, error) { return nil, &noAddressError{"no external address"} })
+	if !errors.As(err, &noAddress) {
+		t.Errorf("got %v, want a *noAddressError", err)
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	noRoute := &url.Error{Op: "Get", URL: "https://api6.ipify.org", Err: &net.OpError{Op: "dial", Net: "tcp6", Err: os.NewSyscallError("connect", syscall.ENETUNREACH)}}
+	if !unreachable(noRoute) {
+		t.Errorf("expected %v to be unreachable", noRoute)
+	}
+
+	refused := &net.OpError{Op: "dial", Net: "tcp6", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	if unreachable(refused) || unreachable(errors.New("status=503")) || unreachable(nil) {
+		t.Errorf("expected only the missing routes to be unreachable")
+	}
+}
+
+func TestIPSourceFlag(t *testing.T) {
+	var f ipSourceFlag
+	if err := f.Set("ipify, icanhazip"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := f.Set("ifconfig.co"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.String() != "ipify,icanhazip,ifconfig.co" {
+		t.Errorf("got %s", f.String())
+	}
+
+	if _, err := newResolverChain(f); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := newResolverChain([]string{"nope"}); err == nil {
+		t.Errorf("expected an error for an unknown source")
+	}
+	if err := f.Set("ipify,"); err == nil {
+		t.Errorf("expected an error for an empty source")
+	}
+}
+
+func TestIPSourceFlagRawSource(t *testing.T) {
+	var f ipSourceFlag
+	if err := f.Set("ipify, exec:/usr/local/bin/wan-ip --format=a,b?x"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(f) != 2 || f[1] != "exec:/usr/local/bin/wan-ip --format=a,b?x" {
+		t.Errorf("got %q, want [ipify exec:/usr/local/bin/wan-ip --format=a,b?x]", []string(f))
+	}
+}
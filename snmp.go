@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+const (
+	snmpDefaultPort = 161
+	snmpTimeout     = 5 * time.Second
+
+	// ifName of IF-MIB, indexed by ifIndex
+	oidIfName = ".1.3.6.1.2.1.31.1.1.1.1"
+	// ipAddressIfIndex of IP-MIB, indexed by ipAddressAddrType and ipAddressAddr
+	oidIPAddressIfIndex = ".1.3.6.1.2.1.4.34.1.3"
+	// ipAdEntIfIndex of the deprecated ipAddrTable, indexed by ipAdEntAddr
+	oidIPAdEntIfIndex = ".1.3.6.1.2.1.4.20.1.2"
+)
+
+// InetAddressType of the ipAddressTable index, see RFC 4001
+const (
+	inetAddressIPv4 = 1
+	inetAddressIPv6 = 2
+)
+
+var snmpAuthProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"MD5":    gosnmp.MD5,
+	"SHA":    gosnmp.SHA,
+	"SHA224": gosnmp.SHA224,
+	"SHA256": gosnmp.SHA256,
+	"SHA384": gosnmp.SHA384,
+	"SHA512": gosnmp.SHA512,
+}
+
+var snmpPrivProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"DES":    gosnmp.DES,
+	"AES":    gosnmp.AES,
+	"AES192": gosnmp.AES192,
+	"AES256": gosnmp.AES256,
+}
+
+// snmpResolver walks the IP-MIB of a router to find the public addresses of
+// its WAN interface, identified by ifIndex or ifName. It falls back to the
+// deprecated ipAddrTable (IPv4 only) on agents without the ipAddressTable.
+type snmpResolver struct {
+	host    string
+	port    uint16
+	ifIndex int
+	ifName  string
+	// snmp holds the version and the credentials, Target is set per request
+	snmp gosnmp.GoSNMP
+}
+
+var _ IPResolver = (*snmpResolver)(nil)
+
+// newSNMPResolver configures an SNMPv2c client using the SNMP_COMMUNITY
+// environment variable, or an SNMPv3 one with ?version=3&user=<name>. The v3
+// security level follows the options: ?auth=<protocol> with the
+// SNMP_AUTH_PASSWORD environment variable, ?priv=<protocol> with
+// SNMP_PRIV_PASSWORD.
+func newSNMPResolver(address string, opts url.Values) (*snmpResolver, error) {
+	if address == "" {
+		return nil, fmt.Errorf("missing router address, expected snmp:<host>[:<port>]")
+	}
+
+	r := &snmpResolver{host: address, port: snmpDefaultPort, ifName: opts.Get("ifname")}
+	if host, port, err := net.SplitHostPort(address); err == nil {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", port)
+		}
+		r.host, r.port = host, uint16(p)
+	}
+
+	if ifIndex := opts.Get("ifindex"); ifIndex != "" {
+		var err error
+		r.ifIndex, err = strconv.Atoi(ifIndex)
+		if err != nil || r.ifIndex <= 0 {
+			return nil, fmt.Errorf("invalid ifindex %q", ifIndex)
+		}
+	}
+	if (r.ifIndex == 0) == (r.ifName == "") {
+		return nil, fmt.Errorf("expected either ?ifindex=<index> or ?ifname=<name>")
+	}
+
+	r.snmp = gosnmp.GoSNMP{
+		Timeout:            snmpTimeout,
+		Retries:            2,
+		ExponentialTimeout: true,
+		MaxOids:            gosnmp.MaxOids,
+		MaxRepetitions:     20,
+	}
+
+	switch version := opts.Get("version"); version {
+	case "", "2c":
+		r.snmp.Version = gosnmp.Version2c
+		r.snmp.Community = os.Getenv("SNMP_COMMUNITY")
+		if r.snmp.Community == "" {
+			r.snmp.Community = "public"
+		}
+	case "3":
+		params, flags, err := snmpV3Security(opts)
+		if err != nil {
+			return nil, err
+		}
+		r.snmp.Version = gosnmp.Version3
+		r.snmp.SecurityModel = gosnmp.UserSecurityModel
+		r.snmp.SecurityParameters = params
+		r.snmp.MsgFlags = flags
+	default:
+		return nil, fmt.Errorf("unsupported SNMP version %q, expected 2c or 3", version)
+	}
+
+	return r, nil
+}
+
+// snmpV3Security builds the USM parameters of the user
+func snmpV3Security(opts url.Values) (*gosnmp.UsmSecurityParameters, gosnmp.SnmpV3MsgFlags, error) {
+	params := &gosnmp.UsmSecurityParameters{
+		UserName:               opts.Get("user"),
+		AuthenticationProtocol: gosnmp.NoAuth,
+		PrivacyProtocol:        gosnmp.NoPriv,
+	}
+	if params.UserName == "" {
+		return nil, 0, fmt.Errorf("SNMPv3 requires ?user=<name>")
+	}
+
+	flags := gosnmp.NoAuthNoPriv
+
+	if auth := strings.ToUpper(opts.Get("auth")); auth != "" {
+		protocol, ok := snmpAuthProtocols[auth]
+		if !ok {
+			return nil, 0, fmt.Errorf("unsupported SNMPv3 auth protocol %q", auth)
+		}
+		params.AuthenticationProtocol = protocol
+		params.AuthenticationPassphrase = os.Getenv("SNMP_AUTH_PASSWORD")
+		if params.AuthenticationPassphrase == "" {
+			return nil, 0, fmt.Errorf("SNMP_AUTH_PASSWORD is required with ?auth=%s", auth)
+		}
+		flags = gosnmp.AuthNoPriv
+	}
+
+	if priv := strings.ToUpper(opts.Get("priv")); priv != "" {
+		if flags != gosnmp.AuthNoPriv {
+			return nil, 0, fmt.Errorf("?priv=%s requires ?auth=<protocol>", priv)
+		}
+		protocol, ok := snmpPrivProtocols[priv]
+		if !ok {
+			return nil, 0, fmt.Errorf("unsupported SNMPv3 priv protocol %q", priv)
+		}
+		params.PrivacyProtocol = protocol
+		params.PrivacyPassphrase = os.Getenv("SNMP_PRIV_PASSWORD")
+		if params.PrivacyPassphrase == "" {
+			return nil, 0, fmt.Errorf("SNMP_PRIV_PASSWORD is required with ?priv=%s", priv)
+		}
+		flags = gosnmp.AuthPriv
+	}
+
+	return params, flags, nil
+}
+
+func (r *snmpResolver) Name() string {
+	return "snmp:" + net.JoinHostPort(r.host, strconv.Itoa(int(r.port)))
+}
+
+func (r *snmpResolver) Resolve(families ipFamily) (*IPAddrs, error) {
+	client := r.snmp
+	client.Target = r.host
+	client.Port = r.port
+	if client.SecurityParameters != nil {
+		client.SecurityParameters = client.SecurityParameters.Copy()
+	}
+
+	if err := client.Connect(); err != nil {
+		return nil, err
+	}
+	defer client.Conn.Close()
+
+	ifIndex := r.ifIndex
+	if r.ifName != "" {
+		var err error
+		ifIndex, err = snmpIfIndex(&client, r.ifName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ips, err := snmpInterfaceIPs(&client, ifIndex)
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the first global unicast address of each family, the private
+	// IPv4 aside. The other ranges, like CGNAT, are left to the policy so
+	// that --allow-cidr applies.
+	ipAddrs := &IPAddrs{}
+	for i := range ips {
+		ip := ips[i]
+		if !ip.IsGlobalUnicast() {
+			continue
+		}
+		if ip.To4() != nil && ipAddrs.V4 == nil && !ip.IsPrivate() {
+			ipAddrs.V4 = &ip
+		} else if ip.To4() == nil && ipAddrs.V6 == nil {
+			ipAddrs.V6 = &ip
+		}
+	}
+
+	ipAddrs = ipAddrs.only(families)
+	if ipAddrs.V4 == nil && ipAddrs.V6 == nil {
+		return nil, &noAddressError{fmt.Sprintf("no global unicast %s address on ifIndex %d among %s", families, ifIndex, ips)}
+	}
+
+	return ipAddrs, nil
+}
+
+// snmpIfIndex finds the ifIndex of the interface named ifName
+func snmpIfIndex(client *gosnmp.GoSNMP, ifName string) (int, error) {
+	pdus, err := client.BulkWalkAll(oidIfName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to walk ifName: %s", err)
+	}
+
+	for _, pdu := range pdus {
+		name, ok := pdu.Value.([]byte)
+		if !ok || string(name) != ifName {
+			continue
+		}
+
+		index, err := strconv.Atoi(strings.TrimPrefix(pdu.Name, oidIfName+"."))
+		if err != nil {
+			return 0, fmt.Errorf("invalid ifName OID %s", pdu.Name)
+		}
+		return index, nil
+	}
+
+	return 0, fmt.Errorf("no interface named %s", ifName)
+}
+
+// snmpInterfaceIPs lists the addresses assigned to ifIndex
+func snmpInterfaceIPs(client *gosnmp.GoSNMP, ifIndex int) ([]net.IP, error) {
+	pdus, err := client.BulkWalkAll(oidIPAddressIfIndex)
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk ipAddressIfIndex: %s", err)
+	}
+
+	root := oidIPAddressIfIndex
+	if len(pdus) == 0 {
+		pdus, err = client.BulkWalkAll(oidIPAdEntIfIndex)
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk ipAdEntIfIndex: %s", err)
+		}
+		root = oidIPAdEntIfIndex
+	}
+
+	ips := make([]net.IP, 0, len(pdus))
+	for _, pdu := range pdus {
+		if gosnmp.ToBigInt(pdu.Value).Int64() != int64(ifIndex) {
+			continue
+		}
+
+		index, err := parseOIDIndex(strings.TrimPrefix(pdu.Name, root+"."))
+		if err != nil {
+			return nil, fmt.Errorf("invalid OID %s: %s", pdu.Name, err)
+		}
+
+		var ip net.IP
+		if root == oidIPAdEntIfIndex {
+			ip = snmpIndexIP(index)
+		} else if len(index) > 2 && (index[0] == inetAddressIPv4 || index[0] == inetAddressIPv6) && index[1] == len(index)-2 {
+			// ipAddressAddrType.length.address, the zoned types (link-local
+			// addresses) are ignored
+			ip = snmpIndexIP(index[2:])
+		}
+
+		if ip != nil {
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips, nil
+}
+
+// parseOIDIndex splits the sub-identifiers of an OID suffix
+func parseOIDIndex(suffix string) ([]int, error) {
+	parts := strings.Split(suffix, ".")
+	index := make([]int, 0, len(parts))
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, err
+		}
+		index = append(index, n)
+	}
+	return index, nil
+}
+
+// snmpIndexIP converts the sub-identifiers of an index into an address, one
+// byte per sub-identifier
+func snmpIndexIP(index []int) net.IP {
+	if len(index) != net.IPv4len && len(index) != net.IPv6len {
+		return nil
+	}
+
+	ip := make(net.IP, len(index))
+	for i, n := range index {
+		if n < 0 || n > 255 {
+			return nil
+		}
+		ip[i] = byte(n)
+	}
+	return ip
+}
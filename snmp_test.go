@@ -0,0 +1,217 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// startSNMPAgent answers the GetNext and GetBulk requests of community on
+// mib, until the test ends
+func startSNMPAgent(t *testing.T, community string, mib []gosnmp.SnmpPDU) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	sort.Slice(mib, func(i, j int) bool { return compareOIDs(mib[i].Name, mib[j].Name) < 0 })
+
+	// next returns the first variable after oid
+	next := func(oid string) gosnmp.SnmpPDU {
+		for _, pdu := range mib {
+			if compareOIDs(pdu.Name, oid) > 0 {
+				return pdu
+			}
+		}
+		return gosnmp.SnmpPDU{Name: oid, Type: gosnmp.EndOfMibView}
+	}
+
+	go func() {
+		decoder := &gosnmp.GoSNMP{Version: gosnmp.Version2c}
+		buf := make([]byte, 65535)
+
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			req, err := decoder.SnmpDecodePacket(buf[:n])
+			if err != nil || req.Community != community {
+				continue
+			}
+
+			res := &gosnmp.SnmpPacket{
+				Version:   req.Version,
+				Community: req.Community,
+				PDUType:   gosnmp.GetResponse,
+				RequestID: req.RequestID,
+			}
+
+			for _, variable := range req.Variables {
+				oid := variable.Name
+				repetitions := 1
+				if req.PDUType == gosnmp.GetBulkRequest {
+					// gosnmp does not decode the max-repetitions of requests
+					repetitions = 10
+				}
+
+				for i := 0; i < repetitions; i++ {
+					pdu := next(oid)
+					res.Variables = append(res.Variables, pdu)
+					if pdu.Type == gosnmp.EndOfMibView {
+						break
+					}
+					oid = pdu.Name
+				}
+			}
+
+			out, err := res.MarshalMsg()
+			if err != nil {
+				t.Errorf("failed to marshal response: %v", err)
+				return
+			}
+			conn.WriteTo(out, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func compareOIDs(a string, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "."), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "."), ".")
+
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, _ := strconv.Atoi(as[i])
+		y, _ := strconv.Atoi(bs[i])
+		if x != y {
+			return x - y
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// ipAddressIfIndex returns the ipAddressTable row of ip on ifIndex
+func ipAddressIfIndex(ip string, ifIndex int) gosnmp.SnmpPDU {
+	addr := net.ParseIP(ip)
+	addrType := inetAddressIPv6
+	if addr.To4() != nil {
+		addr = addr.To4()
+		addrType = inetAddressIPv4
+	}
+
+	oid := oidIPAddressIfIndex + "." + strconv.Itoa(addrType) + "." + strconv.Itoa(len(addr))
+	for _, b := range addr {
+		oid += "." + strconv.Itoa(int(b))
+	}
+	return gosnmp.SnmpPDU{Name: oid, Type: gosnmp.Integer, Value: ifIndex}
+}
+
+func TestSNMPResolver(t *testing.T) {
+	mib := []gosnmp.SnmpPDU{
+		{Name: oidIfName + ".1", Type: gosnmp.OctetString, Value: []byte("lo")},
+		{Name: oidIfName + ".2", Type: gosnmp.OctetString, Value: []byte("lan0")},
+		{Name: oidIfName + ".3", Type: gosnmp.OctetString, Value: []byte("pppoe0")},
+		{Name: oidIfName + ".4", Type: gosnmp.OctetString, Value: []byte("wwan0")},
+		ipAddressIfIndex("127.0.0.1", 1),
+		ipAddressIfIndex("192.168.1.1", 2),
+		ipAddressIfIndex("109.215.101.49", 3),
+		ipAddressIfIndex("100.64.0.1", 4),
+		ipAddressIfIndex("::1", 1),
+		ipAddressIfIndex("2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82", 3),
+		{Name: ".1.3.6.1.2.1.4.35.1.4.2.1.4.192.168.1.10", Type: gosnmp.OctetString, Value: []byte{0, 1, 2, 3, 4, 5}},
+	}
+	address := startSNMPAgent(t, "s3cr3t", mib)
+
+	t.Setenv("SNMP_COMMUNITY", "s3cr3t")
+
+	resolver, err := newSNMPResolver(address, url.Values{"ifname": {"pppoe0"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ipAddrs, err := resolver.Resolve(familyBoth)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ipString(ipAddrs.V4) != "109.215.101.49" || ipString(ipAddrs.V6) != "2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82" {
+		t.Errorf("got %s, want [109.215.101.49 2a01:cb19:96a:7c00:13b0:5ba3:16ae:6c82]", ipAddrs)
+	}
+
+	resolver, _ = newSNMPResolver(address, url.Values{"ifindex": {"2"}})
+	if _, err := resolver.Resolve(familyBoth); err == nil || !strings.Contains(err.Error(), "no global unicast") {
+		t.Errorf("got %v, want a no global unicast address error", err)
+	}
+
+	// CGNAT is left to the policy, which may allow it with --allow-cidr
+	resolver, _ = newSNMPResolver(address, url.Values{"ifname": {"wwan0"}})
+	ipAddrs, err = resolver.Resolve(familyV4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ipString(ipAddrs.V4) != "100.64.0.1" {
+		t.Errorf("got %s, want [100.64.0.1]", ipAddrs)
+	}
+}
+
+func TestSNMPResolverIPAddrTable(t *testing.T) {
+	mib := []gosnmp.SnmpPDU{
+		{Name: oidIPAdEntIfIndex + ".109.215.101.49", Type: gosnmp.Integer, Value: 3},
+		{Name: oidIPAdEntIfIndex + ".127.0.0.1", Type: gosnmp.Integer, Value: 1},
+	}
+	address := startSNMPAgent(t, "public", mib)
+
+	resolver, err := newSNMPResolver(address, url.Values{"ifindex": {"3"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ipAddrs, err := resolver.Resolve(familyBoth)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ipString(ipAddrs.V4) != "109.215.101.49" || ipAddrs.V6 != nil {
+		t.Errorf("got %s, want [109.215.101.49]", ipAddrs)
+	}
+}
+
+func TestNewSNMPResolver(t *testing.T) {
+	t.Setenv("SNMP_AUTH_PASSWORD", "authpass")
+
+	tests := []struct {
+		address string
+		opts    url.Values
+		wantErr string
+	}{
+		{"10.0.0.1", url.Values{"ifindex": {"3"}}, ""},
+		{"10.0.0.1:1161", url.Values{"ifname": {"wan"}}, ""},
+		{"10.0.0.1", url.Values{}, "either"},
+		{"10.0.0.1", url.Values{"ifindex": {"3"}, "ifname": {"wan"}}, "either"},
+		{"10.0.0.1", url.Values{"ifindex": {"x"}}, "invalid ifindex"},
+		{"10.0.0.1", url.Values{"ifindex": {"3"}, "version": {"1"}}, "unsupported SNMP version"},
+		{"10.0.0.1", url.Values{"ifindex": {"3"}, "version": {"3"}}, "?user="},
+		{"10.0.0.1", url.Values{"ifindex": {"3"}, "version": {"3"}, "user": {"dyndns"}, "auth": {"sha"}}, ""},
+		{"10.0.0.1", url.Values{"ifindex": {"3"}, "version": {"3"}, "user": {"dyndns"}, "auth": {"sha"}, "priv": {"aes"}}, "SNMP_PRIV_PASSWORD"},
+		{"10.0.0.1", url.Values{"ifindex": {"3"}, "version": {"3"}, "user": {"dyndns"}, "priv": {"aes"}}, "requires ?auth="},
+		{"", url.Values{"ifindex": {"3"}}, "missing router address"},
+	}
+
+	for _, tt := range tests {
+		_, err := newSNMPResolver(tt.address, tt.opts)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s?%s: unexpected error: %v", tt.address, tt.opts.Encode(), err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s?%s: got %v, want an error containing %q", tt.address, tt.opts.Encode(), err, tt.wantErr)
+		}
+	}
+}
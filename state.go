@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// state is what dyndns remembers between two runs, stored as JSON in the
+// --state-file
+type state struct {
+	// Pins are indexed by the FQDN of their record, like www.example.com
+	Pins map[string]*pin `json:"pins,omitempty"`
+	// Published are indexed by FQDN too, they are only tracked with
+	// --managed-value
+	Published map[string]*published `json:"published,omitempty"`
+}
+
+// pin freezes a record to addresses set by hand until an expiry time
+type pin struct {
+	V4    *net.IP   `json:"v4,omitempty"`
+	V6    *net.IP   `json:"v6,omitempty"`
+	Until time.Time `json:"until"`
+}
+
+func (p *pin) ipAddrs() *IPAddrs {
+	return &IPAddrs{V4: p.V4, V6: p.V6}
+}
+
+// published are the addresses dyndns wrote last in the rrsets of a record
+type published struct {
+	V4 *net.IP `json:"v4,omitempty"`
+	V6 *net.IP `json:"v6,omitempty"`
+}
+
+func (p *published) equal(other *published) bool {
+	return equalIPs(p.V4, other.V4) && equalIPs(p.V6, other.V6)
+}
+
+func equalIPs(a *net.IP, b *net.IP) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// defaultStateFile follows the XDG base directory specification
+func defaultStateFile() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "dyndns.json"
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "dyndns", "state.json")
+}
+
+// loadState reads the state stored at path. A missing file is an empty state.
+func loadState(path string) (*state, error) {
+	s := &state{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the state: %s", err)
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("failed to parse the state file %s: %s", path, err)
+	}
+	return s, nil
+}
+
+// save writes the state atomically to path
+func (s *state) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to save the state: %s", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to save the state: %s", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to save the state: %s", err)
+	}
+	return nil
+}
+
+// activePin returns the pin of fqdn if it has not expired yet. It reports
+// whether an expired pin was dropped, in which case the state must be saved.
+func (s *state) activePin(fqdn string, now time.Time) (active *pin, expired bool) {
+	p, ok := s.Pins[fqdn]
+	if !ok {
+		return nil, false
+	}
+
+	if !now.Before(p.Until) {
+		delete(s.Pins, fqdn)
+		return nil, true
+	}
+	return p, false
+}
+
+func (s *state) setPin(fqdn string, p *pin) {
+	if s.Pins == nil {
+		s.Pins = make(map[string]*pin)
+	}
+	s.Pins[fqdn] = p
+}
+
+// published returns the addresses last published for fqdn, which are empty
+// when nothing was published yet
+func (s *state) published(fqdn string) *published {
+	if p, ok := s.Published[fqdn]; ok {
+		return p
+	}
+	return &published{}
+}
+
+func (s *state) setPublished(fqdn string, p *published) {
+	if s.Published == nil {
+		s.Published = make(map[string]*published)
+	}
+	s.Published[fqdn] = p
+}
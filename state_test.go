@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dyndns", "state.json")
+
+	s, err := loadState(path)
+	if err != nil {
+		t.Fatalf("unexpected error on a missing state: %v", err)
+	}
+	if len(s.Pins) != 0 {
+		t.Errorf("got %d pins, want an empty state", len(s.Pins))
+	}
+
+	ip := net.ParseIP("203.0.113.7")
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	s.setPin("www.example.com", &pin{V4: &ip, Until: now.Add(time.Hour)})
+	if err := s.save(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s, err = loadState(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	p, expired := s.activePin("www.example.com", now)
+	if p == nil || expired || !p.V4.Equal(ip) || p.V6 != nil {
+		t.Fatalf("got pin %+v expired=%t, want an active pin to %s", p, expired, ip)
+	}
+
+	if p, _ := s.activePin("pi.example.com", now); p != nil {
+		t.Errorf("got a pin for a record never pinned")
+	}
+
+	p, expired = s.activePin("www.example.com", now.Add(time.Hour))
+	if p != nil || !expired {
+		t.Errorf("got pin %+v expired=%t, want an expired pin", p, expired)
+	}
+	if _, ok := s.Pins["www.example.com"]; ok {
+		t.Errorf("the expired pin was not removed")
+	}
+}
+
+func TestParseUntil(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{"48h", now.Add(48 * time.Hour), false},
+		{"2026-10-20T08:00:00Z", time.Date(2026, 10, 20, 8, 0, 0, 0, time.UTC), false},
+		{"-1h", time.Time{}, true},
+		{"2026-10-17T08:00:00Z", time.Time{}, true},
+		{"tomorrow", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseUntil(tt.value, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%s: got %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	stunDefaultServer = "stun.l.google.com:19302"
+	stunRetries       = 3
+	stunTimeout       = 500 * time.Millisecond
+
+	stunMagicCookie = 0x2112A442
+
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+
+	stunAttrMappedAddress    = 0x0001
+	stunAttrXorMappedAddress = 0x0020
+)
+
+// stunResolver sends STUN (RFC 5389) Binding Requests over UDP4 and UDP6 and
+// reads the address the server saw the request coming from
+type stunResolver struct {
+	// server is the host:port of the STUN server
+	server string
+}
+
+var _ IPResolver = (*stunResolver)(nil)
+
+func newSTUNResolver(server string) (*stunResolver, error) {
+	if server == "" {
+		return &stunResolver{stunDefaultServer}, nil
+	}
+
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "3478")
+	}
+	return &stunResolver{server}, nil
+}
+
+func (r *stunResolver) Name() string {
+	return "stun:" + r.server
+}
+
+func (r *stunResolver) Resolve(families ipFamily) (*IPAddrs, error) {
+	return resolveFamilies(families, func(family ipFamily) (net.IP, error) {
+		network := "udp4"
+		if family == familyV6 {
+			network = "udp6"
+		}
+		return r.bind(network)
+	})
+}
+
+// bind sends a Binding Request over network and returns the mapped address
+func (r *stunResolver) bind(network string) (net.IP, error) {
+	conn, err := net.Dial(network, r.server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	req := make([]byte, 20)
+	binary.BigEndian.PutUint16(req[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint32(req[4:8], stunMagicCookie)
+	if _, err := rand.Read(req[8:20]); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1500)
+	for attempt := 0; attempt < stunRetries; attempt++ {
+		if _, err := conn.Write(req); err != nil {
+			return nil, err
+		}
+
+		if err := conn.SetReadDeadline(time.Now().Add(stunTimeout)); err != nil {
+			return nil, err
+		}
+
+		n, err := conn.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			return nil, err
+		}
+
+		return parseSTUNResponse(buf[:n], req[8:20])
+	}
+
+	return nil, fmt.Errorf("no answer after %d attempts", stunRetries)
+}
+
+// parseSTUNResponse decodes the (XOR-)MAPPED-ADDRESS of a Binding Response
+func parseSTUNResponse(res []byte, transactionID []byte) (net.IP, error) {
+	if len(res) < 20 {
+		return nil, fmt.Errorf("STUN response too short: %d bytes", len(res))
+	}
+
+	if binary.BigEndian.Uint16(res[0:2]) != stunBindingResponse {
+		return nil, fmt.Errorf("unexpected STUN message type %#04x", binary.BigEndian.Uint16(res[0:2]))
+	}
+
+	if binary.BigEndian.Uint32(res[4:8]) != stunMagicCookie || !bytes.Equal(res[8:20], transactionID) {
+		return nil, errors.New("STUN response does not match the request")
+	}
+
+	length := int(binary.BigEndian.Uint16(res[2:4]))
+	if 20+length > len(res) {
+		return nil, errors.New("truncated STUN response")
+	}
+
+	var mapped net.IP
+	attrs := res[20 : 20+length]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if 4+attrLen > len(attrs) {
+			return nil, errors.New("truncated STUN attribute")
+		}
+		value := attrs[4 : 4+attrLen]
+
+		switch attrType {
+		case stunAttrXorMappedAddress:
+			// the address is XORed with the magic cookie followed by the transaction ID
+			ip, err := parseSTUNAddress(value, res[4:20])
+			if err != nil {
+				return nil, err
+			}
+			return ip, nil
+		case stunAttrMappedAddress:
+			ip, err := parseSTUNAddress(value, make([]byte, 16))
+			if err != nil {
+				return nil, err
+			}
+			mapped = ip
+		}
+
+		// attributes are padded to a multiple of 4 bytes
+		next := 4 + (attrLen+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+
+	if mapped == nil {
+		return nil, errors.New("no mapped address in the STUN response")
+	}
+	return mapped, nil
+}
+
+func parseSTUNAddress(value []byte, mask []byte) (net.IP, error) {
+	if len(value) < 4 {
+		return nil, errors.New("invalid STUN address attribute")
+	}
+
+	var size int
+	switch value[1] {
+	case 0x01:
+		size = net.IPv4len
+	case 0x02:
+		size = net.IPv6len
+	default:
+		return nil, fmt.Errorf("unknown STUN address family %#02x", value[1])
+	}
+
+	if len(value) < 4+size {
+		return nil, errors.New("invalid STUN address attribute")
+	}
+
+	ip := make(net.IP, size)
+	for i := range ip {
+		ip[i] = value[4+i] ^ mask[i]
+	}
+	return ip, nil
+}
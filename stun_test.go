@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// startSTUNServer answers Binding Requests with the XOR-MAPPED-ADDRESS of the
+// client
+func startSTUNServer(t *testing.T, network string, address string) string {
+	conn, err := net.ListenPacket(network, address)
+	if err != nil {
+		t.Skipf("failed to listen on %s: %v", address, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 20 || binary.BigEndian.Uint16(buf[0:2]) != stunBindingRequest {
+				continue
+			}
+
+			udpAddr := addr.(*net.UDPAddr)
+			ip, family := udpAddr.IP.To4(), byte(0x01)
+			if ip == nil {
+				ip, family = udpAddr.IP.To16(), 0x02
+			}
+
+			attr := make([]byte, 8+len(ip))
+			binary.BigEndian.PutUint16(attr[0:2], stunAttrXorMappedAddress)
+			binary.BigEndian.PutUint16(attr[2:4], uint16(4+len(ip)))
+			attr[5] = family
+			binary.BigEndian.PutUint16(attr[6:8], uint16(udpAddr.Port)^uint16(stunMagicCookie>>16))
+			for i := range ip {
+				attr[8+i] = ip[i] ^ buf[4+i]
+			}
+
+			res := make([]byte, 20)
+			binary.BigEndian.PutUint16(res[0:2], stunBindingResponse)
+			binary.BigEndian.PutUint16(res[2:4], uint16(len(attr)))
+			copy(res[4:20], buf[4:20])
+
+			_, _ = conn.WriteTo(append(res, attr...), addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestSTUNResolver(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		address string
+		wantV4  string
+		wantV6  string
+	}{
+		{name: "ipv4", network: "udp4", address: "127.0.0.1:0", wantV4: "127.0.0.1"},
+		{name: "ipv6", network: "udp6", address: "[::1]:0", wantV6: "::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := startSTUNServer(t, tt.network, tt.address)
+
+			resolver, err := newSTUNResolver(server)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			ipAddrs, err := resolver.Resolve(familyBoth)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got := ipString(ipAddrs.V4); got != tt.wantV4 {
+				t.Errorf("got V4 %s, want %s", got, tt.wantV4)
+			}
+			if got := ipString(ipAddrs.V6); got != tt.wantV6 {
+				t.Errorf("got V6 %s, want %s", got, tt.wantV6)
+			}
+		})
+	}
+}
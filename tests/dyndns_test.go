@@ -60,6 +60,66 @@ func TestDyndns(t *testing.T) {
 			mockfile:     "mocks/update-both-with-ttl.yaml",
 			wantExitCode: 0,
 		},
+		{
+			name:         "update ipv4 only",
+			args:         "--domain example.com --record www --ipv4-only",
+			mockfile:     "mocks/update-ipv4-only.yaml",
+			wantExitCode: 0,
+		},
+		{
+			name:         "update ipv4 given on the command line",
+			args:         "--domain example.com --record www --ipv4-only --ipv4 109.215.101.49",
+			mockfile:     "mocks/update-ipv4-override.yaml",
+			wantExitCode: 0,
+		},
+		{
+			name:         "update apex keeps the MX and TXT records",
+			args:         "--domain example.com --record @",
+			mockfile:     "mocks/update-apex-keep-txt.yaml",
+			wantExitCode: 0,
+		},
+		{
+			name:         "ipv4 host delete stale AAAA",
+			args:         "--domain example.com --record www --stale-records delete --ip-source http:https://api.ipify.org#family=ipv4",
+			mockfile:     "mocks/ipv4-host-delete-stale.yaml",
+			wantExitCode: 0,
+		},
+		{
+			name:         "ipv6 detection failed updates ipv4 only",
+			args:         "--domain example.com --record www --stale-records delete",
+			mockfile:     "mocks/update-ipv4-ipv6-failed.yaml",
+			wantExitCode: 3,
+		},
+		{
+			name:         "create missing record",
+			args:         "--domain example.com --record www",
+			mockfile:     "mocks/create-missing-record.yaml",
+			wantExitCode: 0,
+		},
+		{
+			name:         "missing record with --create-if-missing fail",
+			args:         "--domain example.com --record www --create-if-missing fail",
+			mockfile:     "mocks/missing-record-fail.yaml",
+			wantExitCode: 7,
+		},
+		{
+			name:         "gandi rejects the token",
+			args:         "--domain example.com --record www",
+			mockfile:     "mocks/gandi-unauthorized.yaml",
+			wantExitCode: 4,
+		},
+		{
+			name:         "livebox up to date",
+			args:         "--domain example.com --record www --ip-source livebox",
+			mockfile:     "mocks/livebox.yaml",
+			wantExitCode: 0,
+		},
+		{
+			name:         "http json source up to date",
+			args:         "--domain example.com --record www --ipv4-only --ip-source http:https://ifconfig.co/json#json=ip&family=ipv4&header=Accept:application/json",
+			mockfile:     "mocks/http-json.yaml",
+			wantExitCode: 0,
+		},
 	}
 
 	for _, tt := range tests {
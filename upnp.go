@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	ssdpMulticastAddr = "239.255.255.250:1900"
+	ssdpTimeout       = 3 * time.Second
+)
+
+// WAN connection services exposing GetExternalIPAddress, in order of preference
+var upnpWANServices = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// upnpResolver asks the router for its external IPv4 using UPnP IGD.
+// The detection never leaves the local network.
+type upnpResolver struct {
+	// location is the URL of the device description. When empty, the
+	// gateway is discovered with SSDP.
+	location string
+	// ssdpAddr is where the M-SEARCH request is sent
+	ssdpAddr string
+}
+
+var _ IPResolver = (*upnpResolver)(nil)
+
+func newUPnPResolver(location string) (*upnpResolver, error) {
+	if location != "" {
+		if _, err := url.ParseRequestURI(location); err != nil {
+			return nil, fmt.Errorf("invalid device description URL %s: %s", location, err)
+		}
+	}
+	return &upnpResolver{location: location, ssdpAddr: ssdpMulticastAddr}, nil
+}
+
+func (r *upnpResolver) Name() string {
+	return "upnp"
+}
+
+func (r *upnpResolver) Resolve(families ipFamily) (*IPAddrs, error) {
+	if !families.has(familyV4) {
+		return nil, &noAddressError{"UPnP IGD only provides the external IPv4"}
+	}
+
+	location := r.location
+	if location == "" {
+		var err error
+		location, err = r.discover()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	serviceType, controlURL, err := upnpFindWANService(location)
+	if err != nil {
+		return nil, err
+	}
+
+	response := struct {
+		NewExternalIPAddress string
+	}{}
+	err = soapCall(defaultHTTP, controlURL, serviceType, "GetExternalIPAddress", nil, &response)
+	if err != nil {
+		return nil, err
+	}
+
+	ip := net.ParseIP(strings.TrimSpace(response.NewExternalIPAddress))
+	if ip == nil || ip.To4() == nil {
+		return nil, fmt.Errorf("failed to parse external IPv4: %q", response.NewExternalIPAddress)
+	}
+
+	return &IPAddrs{V4: &ip}, nil
+}
+
+// discover sends an SSDP M-SEARCH and returns the LOCATION of the first
+// Internet Gateway Device to answer
+func (r *upnpResolver) discover() (string, error) {
+	raddr, err := net.ResolveUDPAddr("udp4", r.ssdpAddr)
+	if err != nil {
+		return "", err
+	}
+
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	msearch := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpMulticastAddr + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n\r\n"
+
+	if _, err := conn.WriteTo([]byte(msearch), raddr); err != nil {
+		return "", fmt.Errorf("failed to send SSDP M-SEARCH: %s", err)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(ssdpTimeout)); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", fmt.Errorf("no UPnP gateway answered the SSDP M-SEARCH: %s", err)
+		}
+
+		res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		res.Body.Close()
+
+		if location := res.Header.Get("Location"); location != "" {
+			return location, nil
+		}
+	}
+}
+
+type upnpRoot struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+type upnpDevice struct {
+	Services []upnpService `xml:"serviceList>service"`
+	Devices  []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+func (d *upnpDevice) findService(serviceType string) *upnpService {
+	for i := range d.Services {
+		if d.Services[i].ServiceType == serviceType {
+			return &d.Services[i]
+		}
+	}
+	for i := range d.Devices {
+		if service := d.Devices[i].findService(serviceType); service != nil {
+			return service
+		}
+	}
+	return nil
+}
+
+// upnpFindWANService fetches the device description and returns the type and
+// absolute control URL of its WAN connection service
+func upnpFindWANService(location string) (string, string, error) {
+	res, err := defaultHTTP.Get(location)
+	if err != nil {
+		return "", "", err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", "", err
+	}
+
+	if res.StatusCode >= 400 {
+		return "", "", fmt.Errorf("failed to GET %s status=%d", location, res.StatusCode)
+	}
+
+	root := &upnpRoot{}
+	if err := xml.Unmarshal(body, root); err != nil {
+		return "", "", fmt.Errorf("failed to parse device description %s: %s", location, err)
+	}
+
+	base, err := url.Parse(location)
+	if err != nil {
+		return "", "", err
+	}
+	if root.URLBase != "" {
+		if base, err = url.Parse(root.URLBase); err != nil {
+			return "", "", fmt.Errorf("invalid URLBase %s: %s", root.URLBase, err)
+		}
+	}
+
+	for _, serviceType := range upnpWANServices {
+		service := root.Device.findService(serviceType)
+		if service == nil {
+			continue
+		}
+
+		controlURL, err := base.Parse(service.ControlURL)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid controlURL %s: %s", service.ControlURL, err)
+		}
+		return serviceType, controlURL.String(), nil
+	}
+
+	return "", "", fmt.Errorf("no WAN connection service found in %s", location)
+}
+
+// soapCall invokes action, which takes no argument, and decodes the content
+// of its response element into response. When credentials are given, they
+// are used to answer an HTTP Digest challenge.
+func soapCall(client *http.Client, controlURL string, serviceType string, action string, credentials *url.Userinfo, response interface{}) error {
+	payload := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:` + action + ` xmlns:u="` + serviceType + `"/></s:Body>` +
+		`</s:Envelope>`
+
+	newRequest := func() (*http.Request, error) {
+		req, err := http.NewRequest(http.MethodPost, controlURL, strings.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-type", `text/xml; charset="utf-8"`)
+		req.Header.Set("SOAPAction", `"`+serviceType+"#"+action+`"`)
+		return req, nil
+	}
+
+	req, err := newRequest()
+	if err != nil {
+		return err
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode == http.StatusUnauthorized && credentials != nil {
+		res.Body.Close()
+
+		password, _ := credentials.Password()
+		authorization, err := digestAuthorization(req, res, credentials.Username(), password)
+		if err != nil {
+			return fmt.Errorf("%s failed: %s", action, err)
+		}
+
+		req, err = newRequest()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", authorization)
+
+		res, err = client.Do(req)
+		if err != nil {
+			return err
+		}
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("%s failed: unauthorized, check the credentials", action)
+	}
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	envelope := struct {
+		Body struct {
+			Fault *struct {
+				FaultString string `xml:"faultstring"`
+			} `xml:"Fault"`
+			Response struct {
+				Inner []byte `xml:",innerxml"`
+			} `xml:",any"`
+		} `xml:"Body"`
+	}{}
+
+	if err := xml.Unmarshal(body, &envelope); err != nil {
+		return fmt.Errorf("failed to parse %s response status=%d: %s", action, res.StatusCode, err)
+	}
+
+	if fault := envelope.Body.Fault; fault != nil {
+		return fmt.Errorf("%s failed: %s", action, fault.FaultString)
+	}
+
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("%s failed status=%d response=%s", action, res.StatusCode, body)
+	}
+
+	inner := append(append([]byte("<r>"), envelope.Body.Response.Inner...), "</r>"...)
+	if err := xml.Unmarshal(inner, response); err != nil {
+		return fmt.Errorf("failed to parse %s response: %s", action, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUPnPResolver(t *testing.T) {
+	igd := startFakeIGD(t, "109.215.101.49")
+
+	t.Run("ssdp discovery", func(t *testing.T) {
+		resolver, err := newUPnPResolver("")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resolver.ssdpAddr = igd.ssdpAddr()
+
+		ipAddrs, err := resolver.Resolve(familyBoth)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ipAddrs.V4 == nil || ipAddrs.V4.String() != "109.215.101.49" {
+			t.Errorf("got %s, want [109.215.101.49]", ipAddrs)
+		}
+		if ipAddrs.V6 != nil {
+			t.Errorf("unexpected IPv6 %s", ipAddrs.V6)
+		}
+	})
+
+	t.Run("known location", func(t *testing.T) {
+		resolver, err := newUPnPResolver(igd.location())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		ipAddrs, err := resolver.Resolve(familyBoth)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ipAddrs.V4 == nil || ipAddrs.V4.String() != "109.215.101.49" {
+			t.Errorf("got %s, want [109.215.101.49]", ipAddrs)
+		}
+	})
+
+	t.Run("invalid external ip", func(t *testing.T) {
+		igd := startFakeIGD(t, "")
+
+		resolver, _ := newUPnPResolver(igd.location())
+		if _, err := resolver.Resolve(familyBoth); err == nil {
+			t.Errorf("expected an error when the gateway has no external IP")
+		}
+	})
+}
+
+const fakeIGDServiceType = "urn:schemas-upnp-org:service:WANIPConnection:1"
+
+// fakeIGD is a fake UPnP Internet Gateway Device. It answers SSDP M-SEARCH
+// requests on a local UDP port and serves its description and the
+// WANIPConnection control endpoint over HTTP.
+type fakeIGD struct {
+	// externalIP is returned by GetExternalIPAddress
+	externalIP string
+
+	conn   net.PacketConn
+	server *httptest.Server
+}
+
+// startFakeIGD starts a fake IGD answering externalIP, closed at the end of
+// the test
+func startFakeIGD(t *testing.T, externalIP string) *fakeIGD {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	igd := &fakeIGD{externalIP: externalIP, conn: conn}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", igd.serveDescription)
+	mux.HandleFunc("/ctl/IPConn", igd.serveControl)
+	igd.server = httptest.NewServer(mux)
+
+	go igd.serveSSDP()
+
+	t.Cleanup(func() {
+		igd.conn.Close()
+		igd.server.Close()
+	})
+	return igd
+}
+
+// ssdpAddr is the address where M-SEARCH requests must be sent
+func (igd *fakeIGD) ssdpAddr() string {
+	return igd.conn.LocalAddr().String()
+}
+
+// location is the URL of the device description
+func (igd *fakeIGD) location() string {
+	return igd.server.URL + "/rootDesc.xml"
+}
+
+func (igd *fakeIGD) serveSSDP() {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := igd.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		if !strings.HasPrefix(string(buf[:n]), "M-SEARCH * HTTP/1.1\r\n") {
+			continue
+		}
+
+		response := "HTTP/1.1 200 OK\r\n" +
+			"CACHE-CONTROL: max-age=120\r\n" +
+			"ST: urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+			"USN: uuid:fake-igd::urn:schemas-upnp-org:device:InternetGatewayDevice:1\r\n" +
+			"EXT:\r\n" +
+			"SERVER: fakeigd UPnP/1.1\r\n" +
+			"LOCATION: " + igd.location() + "\r\n\r\n"
+
+		_, _ = igd.conn.WriteTo([]byte(response), addr)
+	}
+}
+
+func (igd *fakeIGD) serveDescription(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/xml")
+	fmt.Fprintf(w, `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>%s</serviceType>
+                <controlURL>/ctl/IPConn</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`, fakeIGDServiceType)
+}
+
+func (igd *fakeIGD) serveControl(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	if r.Header.Get("SOAPAction") != `"`+fakeIGDServiceType+`#GetExternalIPAddress"` || !strings.Contains(string(body), "GetExternalIPAddress") {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">
+  <s:Body><s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring></s:Fault></s:Body>
+</s:Envelope>`)
+		return
+	}
+
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	fmt.Fprintf(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+  <s:Body>
+    <u:GetExternalIPAddressResponse xmlns:u="%s">
+      <NewExternalIPAddress>%s</NewExternalIPAddress>
+    </u:GetExternalIPAddressResponse>
+  </s:Body>
+</s:Envelope>`, fakeIGDServiceType, igd.externalIP)
+}